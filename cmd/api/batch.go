@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"greenlight.swsd2544.net/internal/data"
+	"greenlight.swsd2544.net/internal/errcode"
+	"greenlight.swsd2544.net/internal/validator"
+)
+
+const maxBatchRequests = 100
+
+var batchMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// batchHeaders are the sub-response headers passed back in a batch response.
+var batchHeaders = []string{"Location", "ETag", "Content-Language"}
+
+// errBatchFailed stops an atomic batch at the first sub-request that fails,
+// rolling back the ones before it.
+var errBatchFailed = errors.New("batch sub-request failed")
+
+var (
+	codeBatchRequestsRequired = errcode.New("batch.requests.required", "must contain at least one request")
+	codeBatchRequestsTooMany  = errcode.New("batch.requests.too_many", "must not contain more than {max} requests")
+	codeBatchMethodInvalid    = errcode.New("batch.method.invalid", "must be one of {methods}")
+	codeBatchPathInvalid      = errcode.New("batch.path.invalid", "must be an API path starting with /v1/, other than /v1/batch")
+	codeBatchPathNotAtomic    = errcode.New("batch.path.not_atomic", "must be a movie endpoint in an atomic batch")
+	codeBatchHeaderNotAllowed = errcode.New("batch.headers.not_allowed", "must not include {header}")
+)
+
+type batchRequest struct {
+	Headers map[string]string `json:"headers"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+type batchResponse struct {
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+	Status  int               `json:"status"`
+}
+
+func validateBatch(v *validator.Validator, requests []batchRequest, atomic bool) {
+	v.Check(len(requests) > 0, "requests", codeBatchRequestsRequired)
+	v.Check(len(requests) <= maxBatchRequests, "requests", codeBatchRequestsTooMany, errcode.Params{"max": maxBatchRequests})
+
+	for i, request := range requests {
+		v.Check(validator.PermittedValue(request.Method, batchMethods...), validator.Path("requests", i, "method"), codeBatchMethodInvalid, errcode.Params{"methods": batchMethods})
+
+		path, _, _ := strings.Cut(request.Path, "?")
+		valid := strings.HasPrefix(path, "/v1/") && path != "/v1/batch" && !strings.Contains(path, "..")
+		v.Check(valid, validator.Path("requests", i, "path"), codeBatchPathInvalid)
+
+		if atomic && valid {
+			movies := path == "/v1/movies" || strings.HasPrefix(path, "/v1/movies/")
+			v.Check(movies, validator.Path("requests", i, "path"), codeBatchPathNotAtomic)
+		}
+
+		for name := range request.Headers {
+			if http.CanonicalHeaderKey(name) == "Idempotency-Key" {
+				v.AddError(validator.Path("requests", i, "headers"), codeBatchHeaderNotAllowed, errcode.Params{"header": "Idempotency-Key"})
+			}
+		}
+	}
+}
+
+var _ http.ResponseWriter = (*batchResponseWriter)(nil)
+
+// batchResponseWriter records a sub-response.
+type batchResponseWriter struct {
+	header        http.Header
+	body          bytes.Buffer
+	statusCode    int
+	headerWritten bool
+}
+
+func (bw *batchResponseWriter) Header() http.Header {
+	return bw.header
+}
+
+func (bw *batchResponseWriter) Write(b []byte) (int, error) {
+	if !bw.headerWritten {
+		bw.WriteHeader(http.StatusOK)
+	}
+
+	return bw.body.Write(b)
+}
+
+func (bw *batchResponseWriter) WriteHeader(statusCode int) {
+	if !bw.headerWritten {
+		bw.statusCode = statusCode
+		bw.headerWritten = true
+	}
+}
+
+// batchHandler runs a list of sub-requests through next, one after another,
+// and returns their responses in the same order. Sub-requests carry the
+// batch's credentials and preferred language, so they are authenticated and
+// checked for permissions as if they were sent on their own.
+//
+// An atomic batch runs its movie operations in one database transaction: it
+// stops at the first sub-request that fails and rolls back the ones before
+// it, and those after it aren't run and get a 424 Failed Dependency.
+func (app *application) batchHandler(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Requests []batchRequest `json:"requests"`
+			Atomic   bool           `json:"atomic"`
+		}
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		v := validator.New()
+
+		if validateBatch(v, input.Requests, input.Atomic); !v.Valid() {
+			app.failedValidationResponse(w, r, v)
+			return
+		}
+
+		responses := make([]*batchResponse, len(input.Requests))
+
+		run := func(i int, models *data.Models) error {
+			sub, err := app.newBatchSubRequest(r, input.Requests[i])
+			if err != nil {
+				return err
+			}
+
+			if models != nil {
+				sub = app.contextSetModels(sub, *models)
+			}
+
+			bw := &batchResponseWriter{header: make(http.Header), statusCode: http.StatusOK}
+			next.ServeHTTP(bw, sub)
+
+			responses[i] = newBatchResponse(bw)
+
+			return nil
+		}
+
+		if !input.Atomic {
+			for i := range input.Requests {
+				err = run(i, nil)
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+			}
+
+			err = app.writeJSON(w, r, http.StatusOK, envelope{"responses": responses}, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		err = app.models.Atomic(r.Context(), func(models data.Models) error {
+			for i := range input.Requests {
+				err := run(i, &models)
+				if err != nil {
+					return err
+				}
+
+				if responses[i].Status >= http.StatusBadRequest {
+					return errBatchFailed
+				}
+			}
+
+			return nil
+		})
+		if err != nil && !errors.Is(err, errBatchFailed) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		committed := err == nil
+
+		for i := range responses {
+			if responses[i] == nil {
+				responses[i] = &batchResponse{Status: http.StatusFailedDependency}
+			}
+		}
+
+		err = app.writeJSON(w, r, http.StatusOK, envelope{"responses": responses, "committed": committed}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// newBatchSubRequest builds the request for one entry of a batch. Bodies and
+// responses are always JSON, as they are embedded in the batch's own.
+func (app *application) newBatchSubRequest(r *http.Request, request batchRequest) (*http.Request, error) {
+	var body *bytes.Reader
+	if len(request.Body) > 0 && string(request.Body) != "null" {
+		body = bytes.NewReader(request.Body)
+	} else {
+		body = bytes.NewReader(nil)
+	}
+
+	sub, err := http.NewRequestWithContext(r.Context(), request.Method, request.Path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	sub.RemoteAddr = r.RemoteAddr
+	sub.Host = r.Host
+	sub.TLS = r.TLS
+
+	for _, name := range append([]string{"Authorization", "Accept-Language"}, forwardedHeaders...) {
+		if value := r.Header.Get(name); value != "" {
+			sub.Header.Set(name, value)
+		}
+	}
+
+	for name, value := range request.Headers {
+		sub.Header.Set(name, value)
+	}
+
+	sub.Header.Set("Accept", "application/json")
+	if body.Len() > 0 && sub.Header.Get("Content-Type") == "" {
+		sub.Header.Set("Content-Type", "application/json")
+	}
+
+	return sub, nil
+}
+
+func newBatchResponse(bw *batchResponseWriter) *batchResponse {
+	response := &batchResponse{Status: bw.statusCode}
+
+	for _, name := range batchHeaders {
+		if value := bw.header.Get(name); value != "" {
+			if response.Headers == nil {
+				response.Headers = make(map[string]string)
+			}
+			response.Headers[name] = value
+		}
+	}
+
+	body := bytes.TrimSpace(bw.body.Bytes())
+
+	switch {
+	case len(body) == 0:
+	case json.Valid(body):
+		response.Body = body
+	default:
+		response.Body, _ = json.Marshal(string(body))
+	}
+
+	return response
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"greenlight.swsd2544.net/internal/errcode"
+)
+
+var codeUnsupportedContentEncoding = errcode.New("request.encoding_unsupported", "the {content_encoding} content encoding is not supported, use one of {encodings}")
+
+// contentCoding is a compression scheme responses can be sent in and request
+// bodies can be read in. Writers are pooled, as allocating one per response
+// costs more than compressing a typical body.
+type contentCoding struct {
+	writers   sync.Pool
+	newReader func(r io.Reader) (io.ReadCloser, error)
+	name      string
+}
+
+type resetWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// contentCodings lists the supported codings in order of preference, which
+// breaks ties between codings the client accepts equally.
+var contentCodings = []*contentCoding{
+	{
+		name: "zstd",
+		writers: sync.Pool{New: func() any {
+			enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedDefault))
+			return enc
+		}},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(8<<20))
+			if err != nil {
+				return nil, err
+			}
+			return dec.IOReadCloser(), nil
+		},
+	},
+	{
+		name: "br",
+		writers: sync.Pool{New: func() any {
+			return brotli.NewWriterLevel(nil, 5)
+		}},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(brotli.NewReader(r)), nil
+		},
+	},
+	{
+		name: "gzip",
+		writers: sync.Pool{New: func() any {
+			gz, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+			return gz
+		}},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+}
+
+func contentCodingNames() []string {
+	names := make([]string, len(contentCodings))
+	for i, coding := range contentCodings {
+		names[i] = coding.name
+	}
+
+	return names
+}
+
+// negotiateEncoding returns the coding the client prefers most in
+// Accept-Encoding, or nil when the response should be sent as it is. Codings
+// the client doesn't list are acceptable only through "*".
+func negotiateEncoding(header string) *contentCoding {
+	if header == "" {
+		return nil
+	}
+
+	qualities := make(map[string]float64)
+
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+
+		qualities[strings.ToLower(strings.TrimSpace(name))] = quality
+	}
+
+	var best *contentCoding
+	bestQuality := 0.0
+
+	for _, coding := range contentCodings {
+		quality, ok := qualities[coding.name]
+		if !ok {
+			quality = qualities["*"]
+		}
+
+		if quality > bestQuality {
+			best, bestQuality = coding, quality
+		}
+	}
+
+	return best
+}
+
+// incompressible reports whether a response of the given content type is
+// already compressed, so that compressing it again would only cost time.
+func incompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case mediaType == "image/svg+xml":
+		return false
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "video/"),
+		strings.HasPrefix(mediaType, "audio/"), strings.HasPrefix(mediaType, "font/woff"):
+		return true
+	}
+
+	switch mediaType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+		"application/x-brotli", "application/x-7z-compressed", "application/x-rar-compressed",
+		"application/pdf":
+		return true
+	}
+
+	return false
+}
+
+var _ http.ResponseWriter = (*compressResponseWriter)(nil)
+
+// compressResponseWriter holds back the body until it is at least minBytes
+// long, or the handler is done or flushes, and then sends it compressed with
+// coding if the response is worth compressing and uncompressed otherwise.
+type compressResponseWriter struct {
+	wrapped    http.ResponseWriter
+	coding     *contentCoding
+	writer     resetWriter
+	buf        []byte
+	minBytes   int
+	statusCode int
+	decided    bool
+}
+
+func newCompressResponseWriter(w http.ResponseWriter, coding *contentCoding, minBytes int) *compressResponseWriter {
+	return &compressResponseWriter{
+		wrapped:    w,
+		coding:     coding,
+		minBytes:   minBytes,
+		statusCode: http.StatusOK,
+	}
+}
+
+func (cw *compressResponseWriter) Header() http.Header {
+	return cw.wrapped.Header()
+}
+
+func (cw *compressResponseWriter) WriteHeader(statusCode int) {
+	if cw.decided || statusCode < http.StatusOK {
+		cw.wrapped.WriteHeader(statusCode)
+		return
+	}
+
+	cw.statusCode = statusCode
+}
+
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.minBytes {
+			return len(b), nil
+		}
+
+		err := cw.decide(true)
+		return len(b), err
+	}
+
+	if cw.writer != nil {
+		return cw.writer.Write(b)
+	}
+
+	return cw.wrapped.Write(b)
+}
+
+// decide sends the headers and whatever body is held back, compressing from
+// here on if large is set and the response can be compressed.
+func (cw *compressResponseWriter) decide(large bool) error {
+	cw.decided = true
+
+	h := cw.wrapped.Header()
+
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	compress := large &&
+		cw.statusCode != http.StatusNoContent &&
+		cw.statusCode != http.StatusNotModified &&
+		cw.statusCode != http.StatusPartialContent &&
+		h.Get("Content-Encoding") == "" &&
+		h.Get("Content-Range") == "" &&
+		!incompressible(h.Get("Content-Type"))
+
+	if compress {
+		h.Set("Content-Encoding", cw.coding.name)
+		h.Del("Content-Length")
+
+		cw.writer = cw.coding.writers.Get().(resetWriter)
+		cw.writer.Reset(cw.wrapped)
+	}
+
+	cw.wrapped.WriteHeader(cw.statusCode)
+
+	buf := cw.buf
+	cw.buf = nil
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.writer != nil {
+		_, err = cw.writer.Write(buf)
+	} else {
+		_, err = cw.wrapped.Write(buf)
+	}
+
+	return err
+}
+
+// FlushError sends everything written so far. Streaming handlers flush
+// before the body reaches minBytes, so a flush compresses whatever it has.
+func (cw *compressResponseWriter) FlushError() error {
+	if !cw.decided {
+		err := cw.decide(len(cw.buf) > 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cw.writer != nil {
+		err := cw.writer.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	return http.NewResponseController(cw.wrapped).Flush()
+}
+
+func (cw *compressResponseWriter) Flush() {
+	_ = cw.FlushError()
+}
+
+// Close sends what is held back and ends the compressed stream, returning the
+// writer to its pool.
+func (cw *compressResponseWriter) Close() error {
+	var err error
+
+	if !cw.decided {
+		err = cw.decide(len(cw.buf) >= cw.minBytes)
+	}
+
+	if cw.writer != nil {
+		if errClose := cw.writer.Close(); err == nil {
+			err = errClose
+		}
+		cw.coding.writers.Put(cw.writer)
+		cw.writer = nil
+	}
+
+	return err
+}
+
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.wrapped
+}
+
+// compress decompresses request bodies sent with a Content-Encoding and
+// compresses responses in the coding the client prefers in Accept-Encoding.
+// Request bodies are limited after decompression by the handlers reading
+// them, so a small compressed body can't expand past those limits.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if encoding := r.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+			var coding *contentCoding
+			for _, c := range contentCodings {
+				if strings.EqualFold(encoding, c.name) {
+					coding = c
+				}
+			}
+
+			if coding == nil {
+				w.Header().Set("Accept-Encoding", strings.Join(contentCodingNames(), ", "))
+				params := errcode.Params{"content_encoding": encoding, "encodings": contentCodingNames()}
+				app.errorResponse(w, r, http.StatusUnsupportedMediaType, codeUnsupportedContentEncoding, params)
+				return
+			}
+
+			body, err := coding.newReader(r.Body)
+			if err != nil {
+				app.badRequestResponse(w, r, errors.New("body is not valid "+coding.name))
+				return
+			}
+
+			defer func() {
+				_ = body.Close()
+			}()
+
+			r.Body = body
+			r.ContentLength = -1
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+		}
+
+		if !app.config.compression.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		coding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if coding == nil || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := newCompressResponseWriter(w, coding, app.config.compression.minBytes)
+
+		defer func() {
+			err := cw.Close()
+			if err != nil {
+				app.logError(r, err)
+			}
+		}()
+
+		next.ServeHTTP(cw, r)
+	})
+}
@@ -23,3 +23,20 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+const modelsContextKey = contextKey("models")
+
+func (app *application) contextSetModels(r *http.Request, models data.Models) *http.Request {
+	ctx := context.WithValue(r.Context(), modelsContextKey, models)
+	return r.WithContext(ctx)
+}
+
+// modelsFor returns the models a request should use: those of the atomic
+// batch it is part of, if any, and the application's otherwise.
+func (app *application) modelsFor(r *http.Request) data.Models {
+	models, ok := r.Context().Value(modelsContextKey).(data.Models)
+	if !ok {
+		return app.models
+	}
+	return models
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	errNotAcceptable        = errors.New("no acceptable representation")
+	errUnsupportedMediaType = errors.New("unsupported media type")
+	errNotCollection        = errors.New("not a collection")
+)
+
+// codec is one representation responses and request bodies can be exchanged
+// in. Every format goes through JSON, so the struct tags and custom
+// marshalers written for JSON (omitempty, Runtime's "102 mins") shape all of
+// them alike. A codec without decode can't be sent as a request body, and
+// one that only encodes collections can't represent a single resource.
+type codec struct {
+	encode          func(w io.Writer, js []byte, pretty bool) error
+	decode          func(r io.Reader) (any, error)
+	name            string
+	mediaType       string
+	aliases         []string
+	collectionsOnly bool
+}
+
+// codecs lists every supported representation in order of preference, which
+// breaks ties between media types the client accepts equally.
+var codecs = []*codec{
+	{
+		name:      "JSON",
+		mediaType: "application/json",
+		aliases:   []string{"application/problem+json"},
+		encode:    encodeJSON,
+	},
+	{
+		name:      "MessagePack",
+		mediaType: "application/msgpack",
+		aliases:   []string{"application/x-msgpack", "application/vnd.msgpack"},
+		encode:    encodeMsgpack,
+		decode:    decodeMsgpack,
+	},
+	{
+		name:      "YAML",
+		mediaType: "application/yaml",
+		aliases:   []string{"application/x-yaml", "text/yaml", "text/x-yaml"},
+		encode:    encodeYAML,
+		decode:    decodeYAML,
+	},
+	{
+		name:            "CSV",
+		mediaType:       "text/csv",
+		encode:          encodeCSV,
+		collectionsOnly: true,
+	},
+}
+
+var jsonCodec = codecs[0]
+
+func (c *codec) matches(mediaType string) bool {
+	return mediaType == c.mediaType || contains(c.aliases, mediaType)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// negotiate returns the codecs the Accept header allows, the ones with the
+// highest quality first. The most specific media range matching a codec sets
+// its quality, so "text/csv;q=0, */*" rules out CSV only. Without an Accept
+// header every codec is acceptable.
+func negotiate(r *http.Request) []*codec {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return codecs
+	}
+
+	type mediaRange struct {
+		mediaType string
+		quality   float64
+	}
+
+	var ranges []mediaRange
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		ranges = append(ranges, mediaRange{mediaType: mediaType, quality: quality})
+	}
+
+	qualities := make(map[*codec]float64, len(codecs))
+	acceptable := []*codec{}
+
+	for _, c := range codecs {
+		specificity, quality := 0, 0.0
+
+		for _, mr := range ranges {
+			kind, _, _ := strings.Cut(c.mediaType, "/")
+
+			var s int
+			switch {
+			case c.matches(mr.mediaType):
+				s = 3
+			case mr.mediaType == kind+"/*":
+				s = 2
+			case mr.mediaType == "*/*":
+				s = 1
+			default:
+				continue
+			}
+
+			if s > specificity {
+				specificity, quality = s, mr.quality
+			}
+		}
+
+		if quality > 0 {
+			qualities[c] = quality
+			acceptable = append(acceptable, c)
+		}
+	}
+
+	sort.SliceStable(acceptable, func(i, j int) bool {
+		return qualities[acceptable[i]] > qualities[acceptable[j]]
+	})
+
+	return acceptable
+}
+
+// readPretty reports whether the pretty query parameter asks for indented
+// output.
+func readPretty(r *http.Request) bool {
+	qs := r.URL.Query()
+	return qs.Has("pretty") && qs.Get("pretty") != "false"
+}
+
+// encodeResponse renders data in the representation the client prefers and
+// returns it along with its media type. CSV only represents collections, so
+// for anything else the next acceptable codec is used. Errors fall back to
+// JSON when nothing else is acceptable, since a client has to be told why its
+// request failed somehow.
+func encodeResponse(r *http.Request, status int, data envelope) ([]byte, string, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	pretty := readPretty(r)
+
+	acceptable := negotiate(r)
+	if status >= 400 {
+		acceptable = append(acceptable, jsonCodec)
+	}
+
+	for _, c := range acceptable {
+		var buf bytes.Buffer
+
+		err = c.encode(&buf, js, pretty)
+		if errors.Is(err, errNotCollection) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		return buf.Bytes(), c.mediaType, nil
+	}
+
+	return nil, "", errNotAcceptable
+}
+
+// canRespond reports whether any codec able to represent a single resource
+// is acceptable, letting handlers refuse a request before acting on it.
+func canRespond(r *http.Request) bool {
+	for _, c := range negotiate(r) {
+		if !c.collectionsOnly {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bodyCodec returns the codec a request body of the given media type is
+// decoded with. JSON itself, and any structured syntax suffixed +json, is
+// read as it is and needs no codec.
+func bodyCodec(mediaType string) (*codec, error) {
+	if jsonCodec.matches(mediaType) || strings.HasSuffix(mediaType, "+json") {
+		return nil, nil
+	}
+
+	for _, c := range codecs {
+		if c.decode != nil && c.matches(mediaType) {
+			return c, nil
+		}
+	}
+
+	return nil, errUnsupportedMediaType
+}
+
+func encodeJSON(w io.Writer, js []byte, pretty bool) error {
+	if pretty {
+		var buf bytes.Buffer
+
+		err := json.Indent(&buf, js, "", "\t")
+		if err != nil {
+			return err
+		}
+		js = buf.Bytes()
+	}
+
+	_, err := w.Write(append(js, '\n'))
+	return err
+}
+
+func encodeMsgpack(w io.Writer, js []byte, _ bool) error {
+	value, err := decodeGeneric(js)
+	if err != nil {
+		return err
+	}
+
+	enc := msgpack.NewEncoder(w)
+	enc.SetSortMapKeys(true)
+
+	return enc.Encode(value)
+}
+
+func decodeMsgpack(r io.Reader) (any, error) {
+	var value any
+
+	err := msgpack.NewDecoder(r).Decode(&value)
+	return value, err
+}
+
+func encodeYAML(w io.Writer, js []byte, _ bool) error {
+	value, err := decodeGeneric(js)
+	if err != nil {
+		return err
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+
+	err = enc.Encode(value)
+	if err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
+func decodeYAML(r io.Reader) (any, error) {
+	dec := yaml.NewDecoder(r)
+
+	var value any
+
+	err := dec.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	err = dec.Decode(&struct{}{})
+	if err != io.EOF {
+		return nil, errors.New("body must only contain a single YAML document")
+	}
+
+	return value, nil
+}
+
+// decodeGeneric decodes JSON into maps, slices and scalars, keeping integers
+// integers rather than widening every number to float64.
+func decodeGeneric(js []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	var value any
+
+	err := dec.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	return numbers(value), nil
+}
+
+func numbers(value any) any {
+	switch value := value.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]any:
+		for key, v := range value {
+			value[key] = numbers(v)
+		}
+	case []any:
+		for i, v := range value {
+			value[i] = numbers(v)
+		}
+	}
+
+	return value
+}
+
+// encodeCSV writes a collection as one row per item under a header of every
+// key the items have, in the order they first appear. An envelope is a
+// collection when it holds a single list of objects, optionally along with
+// its pagination metadata and links. Links have no place in a table, so they
+// are left out of the rows too. Lists of scalars are joined with "|", as in
+// the movie export; other nested values are written as JSON.
+func encodeCSV(w io.Writer, js []byte, _ bool) error {
+	var env map[string]json.RawMessage
+
+	err := json.Unmarshal(js, &env)
+	if err != nil {
+		return err
+	}
+
+	var items []json.RawMessage
+
+	for key, raw := range env {
+		if key == "metadata" || key == "_links" {
+			continue
+		}
+
+		if items != nil || json.Unmarshal(raw, &items) != nil {
+			return errNotCollection
+		}
+	}
+
+	if items == nil {
+		return errNotCollection
+	}
+
+	var header []string
+	rows := make([]map[string]json.RawMessage, len(items))
+
+	for i, item := range items {
+		keys, values, err := orderedObject(item)
+		if err != nil {
+			return errNotCollection
+		}
+
+		for _, key := range keys {
+			if key != "_links" && !contains(header, key) {
+				header = append(header, key)
+			}
+		}
+
+		rows[i] = values
+	}
+
+	cw := csv.NewWriter(w)
+
+	err = cw.Write(header)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		record := make([]string, len(header))
+		for i, key := range header {
+			record[i] = csvCell(row[key])
+		}
+
+		err = cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// orderedObject returns the keys of a JSON object in the order they are
+// written, along with their values.
+func orderedObject(js []byte) ([]string, map[string]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+
+	token, err := dec.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if token != json.Delim('{') {
+		return nil, nil, errors.New("not an object")
+	}
+
+	var keys []string
+	values := make(map[string]json.RawMessage)
+
+	for dec.More() {
+		token, err = dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		key, _ := token.(string)
+
+		var value json.RawMessage
+
+		err = dec.Decode(&value)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		keys = append(keys, key)
+		values[key] = value
+	}
+
+	return keys, values, nil
+}
+
+func csvCell(raw json.RawMessage) string {
+	if raw == nil {
+		return ""
+	}
+
+	value, err := decodeGeneric(raw)
+	if err != nil {
+		return string(raw)
+	}
+
+	if list, ok := value.([]any); ok {
+		parts := make([]string, len(list))
+		for i, v := range list {
+			switch v.(type) {
+			case map[string]any, []any:
+				return string(raw)
+			}
+			parts[i] = csvScalar(v)
+		}
+		return strings.Join(parts, "|")
+	}
+
+	if _, ok := value.(map[string]any); ok {
+		return string(raw)
+	}
+
+	return csvScalar(value)
+}
+
+func csvScalar(value any) string {
+	if value == nil {
+		return ""
+	}
+
+	return fmt.Sprint(value)
+}
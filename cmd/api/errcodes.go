@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+
+	"greenlight.swsd2544.net/internal/errcode"
+	"greenlight.swsd2544.net/internal/i18n"
+)
+
+// listErrorCodesHandler publishes every error code the API can return with
+// its message in the language the client prefers, so clients can match on
+// codes instead of messages. Placeholders are left unfilled.
+func (app *application) listErrorCodesHandler(w http.ResponseWriter, r *http.Request) {
+	language := readMessageLanguage(r)
+
+	entries := errcode.All()
+	for i := range entries {
+		entries[i].Message = i18n.Message(language, entries[i].Code, nil)
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Language", language)
+	headers.Set("Vary", "Accept-Language")
+
+	err := app.writeJSON(w, r, http.StatusOK, envelope{"errors": entries}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
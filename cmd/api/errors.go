@@ -1,93 +1,305 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
+	"greenlight.swsd2544.net/internal/data"
+	"greenlight.swsd2544.net/internal/errcode"
+	"greenlight.swsd2544.net/internal/i18n"
+	"greenlight.swsd2544.net/internal/validator"
 )
 
+var (
+	codeServerError            = errcode.New("server.error", "the server encountered a problem and could not process your request")
+	codeNotFound               = errcode.New("resource.not_found", "the requested resource could not be found")
+	codeMethodNotAllowed       = errcode.New("method.not_allowed", "the {method} method is not supported for this resource")
+	codeBadRequest             = errcode.New("request.malformed", "{reason}")
+	codeValidationFailed       = errcode.New("validation.failed", "one or more fields failed validation")
+	codeUnsupportedMediaType   = errcode.New("request.media_type_unsupported", "the {content_type} content type is not supported for this resource")
+	codeNotAcceptable          = errcode.New("request.not_acceptable", "the resource cannot be represented in any of the accepted media types, accept one of {media_types}")
+	codeContentTooLarge        = errcode.New("request.too_large", "the request body must not be larger than {limit} bytes")
+	codePatchTestFailed        = errcode.New("patch.test_failed", "a test operation in the patch did not match the current state of the resource")
+	codeDuplicateMovie         = errcode.New("movie.duplicate", "a movie with this title and year already exists, retry with force=true to create it anyway")
+	codeEditConflict           = errcode.New("edit.conflict", "unable to update the record due to an edit conflict, please try again")
+	codePreconditionFailed     = errcode.New("precondition.failed", "the resource has been modified since the version given in If-Match")
+	codePreconditionRequired   = errcode.New("precondition.required", "this request must be made conditional with an If-Match header")
+	codeRateLimitExceeded      = errcode.New("rate_limit.exceeded", "rate limit exceeded")
+	codeInvalidCredentials     = errcode.New("auth.credentials_invalid", "invalid authentication credentials")
+	codeInvalidToken           = errcode.New("auth.token_invalid", "invalid or missing authentication token")
+	codeAuthenticationRequired = errcode.New("auth.required", "you must be authenticated to access this resource")
+	codeInactiveAccount        = errcode.New("auth.account_inactive", "your user account must be activated to access this resource")
+	codeNotPermitted           = errcode.New("auth.not_permitted", "your user account doesn't have the necessary permissions to access this resource")
+)
+
+// problemField is one entry of the errors member of a problem details object
+// for a validation failure.
+type problemField struct {
+	Params errcode.Params `json:"params,omitempty"`
+	Field  string         `json:"field"`
+	Code   errcode.Code   `json:"code"`
+	Detail string         `json:"detail"`
+}
+
 func (app *application) logError(r *http.Request, err error) {
 	app.logger.Error().Err(err).Str("request_method", r.Method).Str("request_url", r.URL.String()).Send()
 }
 
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
-	env := envelope{"error": message}
+// apiError is an error response before it is localized and encoded. Fields is
+// set for validation failures.
+type apiError struct {
+	params errcode.Params
+	fields *validator.Validator
+	extra  envelope
+	code   errcode.Code
+	status int
+}
+
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code errcode.Code, params errcode.Params) {
+	app.writeError(w, r, apiError{status: status, code: code, params: params})
+}
 
+// writeError sends e with its stable code and its message in the language the
+// client prefers in Accept-Language. Clients that accept
+// application/problem+json, or every client when problem details are enabled
+// by flag, get a problem details object; the rest get the original
+// {"error": message} envelope. Members in e.extra are added to either body.
+func (app *application) writeError(w http.ResponseWriter, r *http.Request, e apiError) {
 	span := trace.SpanFromContext(r.Context())
-	span.SetStatus(codes.Error, fmt.Sprintf("%s", message))
+	span.SetStatus(codes.Error, string(e.code))
+
+	language := readMessageLanguage(r)
+
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Language", language)
+
+	message := i18n.Message(language, e.code, e.params)
+
+	var (
+		first  map[string]string
+		fields map[string][]validator.FieldError
+	)
+	if e.fields != nil {
+		first, fields = localizeFields(language, e.fields)
+	}
+
+	var err error
+	if app.config.problemDetails || acceptsProblem(r) {
+		err = app.writeProblem(w, r, e, message, fields)
+	} else {
+		env := envelope{"error": message, "code": e.code}
+		if fields != nil {
+			env["error"] = first
+			env["fields"] = fields
+		}
+		for key, value := range e.extra {
+			env[key] = value
+		}
+		err = app.writeJSON(w, r, e.status, env, nil)
+	}
 
-	err := app.writeJSON(w, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
 		span.RecordError(err)
-		span.SetStatus(codes.Error, fmt.Sprintf("failed to write error back to user: %s", message))
+		span.SetStatus(codes.Error, fmt.Sprintf("failed to write error back to user: %s", e.code))
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
+// readMessageLanguage picks the language of error messages from
+// Accept-Language, falling back to English.
+func readMessageLanguage(r *http.Request) string {
+	language := preferredLanguage(r.Header.Get("Accept-Language"), func(language string) bool {
+		return validator.PermittedValue(language, i18n.Languages()...)
+	})
+	if language == "" {
+		return i18n.Fallback
+	}
+
+	return language
+}
+
+// localizeFields translates the failures in v, returning the first message of
+// each field and every failure of each field.
+func localizeFields(language string, v *validator.Validator) (map[string]string, map[string][]validator.FieldError) {
+	first := make(map[string]string, len(v.Fields))
+	fields := make(map[string][]validator.FieldError, len(v.Fields))
+
+	for key, errs := range v.Fields {
+		localized := make([]validator.FieldError, len(errs))
+		for i, fe := range errs {
+			fe.Message = i18n.Message(language, fe.Code, fe.Params)
+			localized[i] = fe
+		}
+
+		first[key] = localized[0].Message
+		fields[key] = localized
+	}
+
+	return first, fields
+}
+
+// writeProblem sends an RFC 9457 problem details object. Validation failures
+// list each invalid field in an errors member rather than in detail.
+func (app *application) writeProblem(w http.ResponseWriter, r *http.Request, e apiError, message string, fields map[string][]validator.FieldError) error {
+	env := envelope{
+		"type":     "about:blank",
+		"title":    http.StatusText(e.status),
+		"status":   e.status,
+		"code":     e.code,
+		"detail":   message,
+		"instance": r.URL.Path,
+	}
+
+	for key, value := range e.extra {
+		env[key] = value
+	}
+
+	if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.HasTraceID() {
+		env["trace_id"] = spanContext.TraceID().String()
+	}
+
+	if fields != nil {
+		errs := make([]problemField, 0, len(fields))
+		for field, fieldErrs := range fields {
+			for _, fe := range fieldErrs {
+				errs = append(errs, problemField{Field: field, Code: fe.Code, Detail: fe.Message, Params: fe.Params})
+			}
+		}
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].Field < errs[j].Field
+		})
+
+		env["errors"] = errs
+	}
+
+	return app.writeJSON(w, r, e.status, env, http.Header{"Content-Type": {"application/problem+json"}})
+}
+
+// acceptsProblem reports whether the Accept header lists
+// application/problem+json with a non-zero quality.
+func acceptsProblem(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || mediaType != "application/problem+json" {
+			continue
+		}
+
+		if q, ok := params["q"]; ok {
+			weight, err := strconv.ParseFloat(q, 64)
+			if err != nil || weight == 0 {
+				continue
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logError(r, err)
 	span := trace.SpanFromContext(r.Context())
 	span.RecordError(err)
-	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, codeServerError, nil)
 }
 
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
-	message := "the requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, codeNotFound, nil)
 }
 
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
-	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, errcode.Params{"method": r.Method})
 }
 
+// badRequestResponse reports a request that couldn't be read. The failures of
+// readJSON that aren't about the body itself get their own status.
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errUnsupportedMediaType):
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	case errors.Is(err, errNotAcceptable):
+		app.notAcceptableResponse(w, r)
+		return
+	}
+
 	span := trace.SpanFromContext(r.Context())
 	span.RecordError(err)
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	app.errorResponse(w, r, http.StatusBadRequest, codeBadRequest, errcode.Params{"reason": err.Error()})
+}
+
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator) {
+	app.writeError(w, r, apiError{status: http.StatusUnprocessableEntity, code: codeValidationFailed, fields: v})
 }
 
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	params := errcode.Params{"content_type": r.Header.Get("Content-Type")}
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, params)
+}
+
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	mediaTypes := make([]string, len(codecs))
+	for i, c := range codecs {
+		mediaTypes[i] = c.mediaType
+	}
+
+	app.errorResponse(w, r, http.StatusNotAcceptable, codeNotAcceptable, errcode.Params{"media_types": mediaTypes})
+}
+
+func (app *application) contentTooLargeResponse(w http.ResponseWriter, r *http.Request, limit int64) {
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, codeContentTooLarge, errcode.Params{"limit": limit})
+}
+
+func (app *application) patchTestFailedResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusConflict, codePatchTestFailed, nil)
+}
+
+func (app *application) duplicateMovieResponse(w http.ResponseWriter, r *http.Request, duplicates []*data.Movie) {
+	app.writeError(w, r, apiError{status: http.StatusConflict, code: codeDuplicateMovie, extra: envelope{"duplicates": duplicates}})
 }
 
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
-	message := "unable to update the record due to an edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
+	app.errorResponse(w, r, http.StatusConflict, codeEditConflict, nil)
+}
+
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusPreconditionFailed, codePreconditionFailed, nil)
+}
+
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusPreconditionRequired, codePreconditionRequired, nil)
 }
 
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
-	message := "rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	app.errorResponse(w, r, http.StatusTooManyRequests, codeRateLimitExceeded, nil)
 }
 
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
-	message := "invalid authentication credentials"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, codeInvalidCredentials, nil)
 }
 
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
-
-	message := "invalid or missing authentication token"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, codeInvalidToken, nil)
 }
 
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
-	message := "you must be authenticated to access this resource"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, codeAuthenticationRequired, nil)
 }
 
 func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
-	message := "your uesr account must be activated to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, codeInactiveAccount, nil)
 }
 
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
-	message := "your user account doesn't have the nexessary permissions to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, codeNotPermitted, nil)
 }
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -13,9 +16,16 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
+	"greenlight.swsd2544.net/internal/data"
+	"greenlight.swsd2544.net/internal/errcode"
 	"greenlight.swsd2544.net/internal/validator"
 )
 
+var (
+	codeParamNotInteger = errcode.New("param.not_integer", "must be an integer value")
+	codeParamNotBoolean = errcode.New("param.not_boolean", "must be a boolean value")
+)
+
 func (app *application) readIDParam(r *http.Request) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
@@ -26,83 +36,314 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+func (app *application) readStringParam(r *http.Request, name string) string {
+	return httprouter.ParamsFromContext(r.Context()).ByName(name)
+}
+
+func (app *application) readInt64Param(r *http.Request, name string) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	value, err := strconv.ParseInt(params.ByName(name), 10, 64)
+	if err != nil || value < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
+	}
+
+	return value, nil
+}
+
+func (app *application) readVersionParam(r *http.Request) (int32, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	version, err := strconv.ParseInt(params.ByName("version"), 10, 32)
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid version parameter")
+	}
+
+	return int32(version), nil
+}
+
 type envelope map[string]any
 
-func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
-	js, err := json.MarshalIndent(data, "", "\t")
+// writeJSON sends data in the representation the client prefers in Accept:
+// compact JSON by default, indented with ?pretty, or MessagePack, YAML or,
+// for collections, CSV. A Content-Type in headers overrides the JSON one,
+// for JSON-based formats such as problem details. Clients that accept none
+// of them get a 406 instead.
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	body, contentType, err := encodeResponse(r, status, data)
+	if err != nil {
+		if errors.Is(err, errNotAcceptable) {
+			app.notAcceptableResponse(w, r)
+			return nil
+		}
+		return err
+	}
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	if etag := headers.Get("ETag"); etag != "" {
+		w.Header().Set("ETag", representationETag(r, etag, contentType, body))
+	}
+
+	if headers.Get("Content-Type") == "" || contentType != jsonCodec.mediaType {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	w.Header().Add("Vary", "Accept")
+
+	w.WriteHeader(status)
+	_, err = w.Write(body)
+
+	return err
+}
+
+// readLocale picks the locale a response should be localized into: the lang
+// query parameter when given, otherwise the supported language the client
+// prefers most in Accept-Language. It returns "" when the client accepts none
+// of them and original titles should be used.
+func (app *application) readLocale(r *http.Request, v *validator.Validator) string {
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		lang = strings.ToLower(lang)
+		data.ValidateLocale(v, "lang", lang)
+		return lang
+	}
+
+	return preferredLanguage(r.Header.Get("Accept-Language"), func(language string) bool {
+		_, ok := data.Locales[language]
+		return ok
+	})
+}
+
+// preferredLanguage returns the supported language with the highest weight in
+// an Accept-Language header value, or "" when none of them are listed.
+// Regional tags count towards their language, so th-TH selects th.
+func preferredLanguage(header string, supported func(language string) bool) string {
+	best, bestWeight := "", 0.0
+
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+
+		weight := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
+
+		language, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+
+		if supported(language) && weight > bestWeight {
+			best, bestWeight = language, weight
+		}
+	}
+
+	return best
+}
+
+// writeConditionalJSON is writeJSON for cacheable GET responses. The ETag in
+// headers is used for JSON if there is one, otherwise a weak ETag is derived
+// from the body. Requests whose If-None-Match matches it get an empty 304
+// instead.
+func (app *application) writeConditionalJSON(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	body, contentType, err := encodeResponse(r, status, data)
 	if err != nil {
+		if errors.Is(err, errNotAcceptable) {
+			app.notAcceptableResponse(w, r)
+			return nil
+		}
 		return err
 	}
 
-	js = append(js, '\n')
+	etag := representationETag(r, headers.Get("ETag"), contentType, body)
 
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", "Accept")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag, false) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(status)
-	_, err = w.Write(js)
+	_, err = w.Write(body)
 
 	return err
 }
 
+// representationETag adapts the strong ETag a handler derived for the compact
+// JSON representation to the one being sent. The indented representation has
+// a strong tag of its own, while the other formats, and responses the handler
+// has no tag for, are told apart by a weak one derived from their body.
+func representationETag(r *http.Request, etag, contentType string, body []byte) string {
+	switch {
+	case etag == "" || contentType != jsonCodec.mediaType:
+		sum := sha256.Sum256(body)
+		return fmt.Sprintf(`W/"%x"`, sum[:16])
+	case readPretty(r):
+		return prettyETag(etag)
+	default:
+		return etag
+	}
+}
+
+// prettyETag returns the strong tag of the indented JSON representation whose
+// compact one is tagged etag.
+func prettyETag(etag string) string {
+	return strings.TrimSuffix(etag, `"`) + `-pretty"`
+}
+
+// etagMatches reports whether any entity tag listed in an If-Match or
+// If-None-Match header value matches etag. Strong comparison (If-Match) never
+// matches weak tags; weak comparison (If-None-Match) ignores the W/ prefix.
+func etagMatches(header, etag string, strong bool) bool {
+	if header == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		switch {
+		case candidate == "*":
+			return true
+		case strong:
+			if candidate == etag && !strings.HasPrefix(etag, "W/") {
+				return true
+			}
+		default:
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// readJSON decodes the request body into dst. Besides JSON, bodies may be
+// sent in any format with a decoding codec, which are converted to JSON and
+// read the same way. It fails with errUnsupportedMediaType for other content
+// types, and with errNotAcceptable when the client accepts no representation
+// of the response, before the handler acts on the request.
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
 	_, span := otel.Tracer(app.config.name).Start(r.Context(), "readJSON")
 	defer span.End()
 
-	maxBytes := 1 << 20 // 1 MB
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	fail := func(err error) error {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
 
-	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields()
+	if !canRespond(r) {
+		return fail(errNotAcceptable)
+	}
 
-	err := dec.Decode(dst)
+	mediaType, err := app.readMediaType(r)
 	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, err.Error())
+		return fail(errUnsupportedMediaType)
+	}
+
+	c, err := bodyCodec(mediaType)
+	if err != nil {
+		return fail(err)
+	}
 
-		var syntaxError *json.SyntaxError
-		var unmarshalTypeError *json.UnmarshalTypeError
-		var invalidUnmarshallError *json.InvalidUnmarshalError
-		var maxBytesError *http.MaxBytesError
+	maxBytes := 1 << 20 // 1 MB
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
 
-		switch {
-		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
-		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly-formed JSON")
-		case errors.As(err, &unmarshalTypeError):
-			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
-			} else {
-				return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+	var body io.Reader = r.Body
+
+	if c != nil {
+		value, err := c.decode(r.Body)
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.Is(err, io.EOF) || errors.As(err, &maxBytesError) {
+				return fail(jsonDecodeError(err))
 			}
-		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
-		case strings.HasPrefix(err.Error(), "json: unknown field"):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
-		case errors.As(err, &maxBytesError):
-			return fmt.Errorf("body must not be larget than %d bytes", maxBytesError.Limit)
-		case errors.As(err, &invalidUnmarshallError):
-			panic(err)
-		default:
-			return err
+			return fail(fmt.Errorf("body contains badly-formed %s", c.name))
+		}
+
+		js, err := json.Marshal(value)
+		if err != nil {
+			return fail(fmt.Errorf("body contains a %s value with no JSON equivalent", c.name))
 		}
+
+		body = bytes.NewReader(js)
+	}
+
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(dst)
+	if err != nil {
+		return fail(jsonDecodeError(err))
 	}
 
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
-		err = errors.New("body must only contains a single JSON value")
-		span.RecordError(err)
-		span.SetStatus(codes.Error, err.Error())
-		return err
+		return fail(errors.New("body must only contain a single JSON value"))
 	}
 
 	return nil
 }
 
+// jsonDecodeError translates an error from decoding a request body into a
+// message that is safe to send back to the client.
+func jsonDecodeError(err error) error {
+	var syntaxError *json.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+	var invalidUnmarshallError *json.InvalidUnmarshalError
+	var maxBytesError *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &syntaxError):
+		return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("body contains badly-formed JSON")
+	case errors.As(err, &unmarshalTypeError):
+		if unmarshalTypeError.Field != "" {
+			return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+		} else {
+			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+		}
+	case errors.Is(err, io.EOF):
+		return errors.New("body must not be empty")
+	case strings.HasPrefix(err.Error(), "json: unknown field"):
+		fieldName := strings.TrimPrefix(err.Error(), "json: unknown field")
+		return fmt.Errorf("body contains unknown key %s", fieldName)
+	case errors.As(err, &maxBytesError):
+		return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+	case errors.As(err, &invalidUnmarshallError):
+		panic(err)
+	default:
+		return err
+	}
+}
+
+// readMediaType returns the media type of the request body without its
+// parameters, defaulting to application/json when no Content-Type is sent.
+func (app *application) readMediaType(r *http.Request) (string, error) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return "application/json", nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", err
+	}
+
+	return mediaType, nil
+}
+
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
 	s := qs.Get(key)
 
@@ -132,13 +373,29 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 	i, err := strconv.Atoi(s)
 	if err != nil {
-		v.AddError(key, "must be an integer value")
+		v.AddError(key, codeParamNotInteger)
 		return defaultValue
 	}
 
 	return i
 }
 
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, codeParamNotBoolean)
+		return defaultValue
+	}
+
+	return b
+}
+
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
 
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"greenlight.swsd2544.net/internal/i18n"
+)
+
+// TestTranslations checks that every error code is translated in every
+// language. It lives in package main so that the codes of every package the
+// API links in are registered.
+func TestTranslations(t *testing.T) {
+	missing := i18n.Missing()
+
+	for _, language := range i18n.Languages() {
+		for _, code := range missing[language] {
+			t.Errorf("%s: missing translation for %s", language, code)
+		}
+	}
+
+	if len(missing) != 0 {
+		t.Fatalf("%d languages have untranslated error codes", len(missing))
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/tomasen/realip"
+	"greenlight.swsd2544.net/internal/data"
+	"greenlight.swsd2544.net/internal/errcode"
+	"greenlight.swsd2544.net/internal/validator"
+)
+
+var (
+	codeIdempotencyKeyMismatch = errcode.New("idempotency_key.mismatch", "this Idempotency-Key was already used for a different request")
+	codeIdempotencyKeyInFlight = errcode.New("idempotency_key.in_flight", "a request with this Idempotency-Key is still being processed, retry later")
+)
+
+// idempotentHeaders are the response headers stored along with the body and
+// sent again when a response is replayed.
+var idempotentHeaders = []string{"Content-Type", "Content-Language", "Location", "ETag"}
+
+var _ http.ResponseWriter = (*idempotencyResponseWriter)(nil)
+
+// idempotencyResponseWriter keeps a copy of the status and body it passes on,
+// so the response can be stored for replay.
+type idempotencyResponseWriter struct {
+	wrapped       http.ResponseWriter
+	body          bytes.Buffer
+	statusCode    int
+	headerWritten bool
+}
+
+func (iw *idempotencyResponseWriter) Header() http.Header {
+	return iw.wrapped.Header()
+}
+
+func (iw *idempotencyResponseWriter) Write(b []byte) (int, error) {
+	if !iw.headerWritten {
+		iw.WriteHeader(http.StatusOK)
+	}
+
+	iw.body.Write(b)
+	return iw.wrapped.Write(b)
+}
+
+func (iw *idempotencyResponseWriter) WriteHeader(statusCode int) {
+	iw.wrapped.WriteHeader(statusCode)
+
+	if !iw.headerWritten {
+		iw.statusCode = statusCode
+		iw.headerWritten = true
+	}
+}
+
+func (iw *idempotencyResponseWriter) Unwrap() http.ResponseWriter {
+	return iw.wrapped
+}
+
+// idempotent lets clients retry a request safely by sending an
+// Idempotency-Key header. The first request with a key is handled as usual
+// and its response stored; retries with the same key and the same request get
+// that response back, marked with Idempotent-Replayed, without the handler
+// running again. Keys are scoped to the user, and a key reused for a different
+// request is rejected, as is a retry while the first request is still being
+// handled. Anonymous keys are scoped to the client's IP address, so clients
+// that happen to pick the same key don't get each other's responses. Server
+// errors aren't stored, so those may be retried afresh.
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		v := validator.New()
+		if data.ValidateIdempotencyKey(v, key); !v.Valid() {
+			app.failedValidationResponse(w, r, v)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				app.contentTooLargeResponse(w, r, maxBytesError.Limit)
+			} else {
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		h := sha256.New()
+		h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n" + r.Header.Get("Content-Type") + "\n"))
+		h.Write(body)
+		fingerprint := h.Sum(nil)
+
+		claim := &data.IdempotencyKey{
+			Key:         key,
+			UserID:      app.contextGetUser(r).ID,
+			Fingerprint: fingerprint,
+		}
+
+		if claim.UserID == data.AnonymousUser.ID {
+			claim.Client = realip.FromRequest(r)
+		}
+
+		existing, claimed, err := app.models.IdempotencyKeys.Begin(claim)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !claimed {
+			switch {
+			case !bytes.Equal(existing.Fingerprint, fingerprint):
+				app.errorResponse(w, r, http.StatusUnprocessableEntity, codeIdempotencyKeyMismatch, nil)
+			case existing.InFlight():
+				w.Header().Set("Retry-After", "1")
+				app.errorResponse(w, r, http.StatusConflict, codeIdempotencyKeyInFlight, nil)
+			default:
+				for name, values := range existing.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.Status)
+				_, _ = w.Write(existing.Body)
+			}
+			return
+		}
+
+		iw := &idempotencyResponseWriter{wrapped: w, statusCode: http.StatusOK}
+
+		completed := false
+
+		defer func() {
+			if completed {
+				return
+			}
+
+			err := app.models.IdempotencyKeys.Release(claim)
+			if err != nil {
+				app.logError(r, err)
+			}
+		}()
+
+		next.ServeHTTP(iw, r)
+
+		if iw.statusCode >= http.StatusInternalServerError {
+			return
+		}
+
+		claim.Header = make(http.Header)
+		claim.Body = iw.body.Bytes()
+		claim.Status = iw.statusCode
+
+		for _, name := range idempotentHeaders {
+			if values := w.Header().Values(name); len(values) > 0 {
+				claim.Header[name] = values
+			}
+		}
+
+		err = app.models.IdempotencyKeys.Complete(claim)
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+
+		completed = true
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// startJobs launches the periodic maintenance jobs. They run as background
+// tasks, so shutdown waits for an in-flight run to finish once stop is closed.
+func (app *application) startJobs(stop <-chan struct{}) {
+	app.every(0, stop, app.backfillTitleKeys)
+
+	if app.config.trash.retentionDays > 0 {
+		app.every(time.Hour, stop, app.purgeExpiredTrash)
+	}
+
+	app.every(time.Hour, stop, app.purgeExpiredIdempotencyKeys)
+
+	if app.config.similar.interval > 0 {
+		app.every(app.config.similar.interval, stop, app.recomputeSimilarities)
+	}
+}
+
+// every runs fn immediately and then on each tick of interval until stop is
+// closed, or only once for an interval of 0. The context passed to fn is
+// cancelled when stop is closed, so long runs don't hold up shutdown.
+func (app *application) every(interval time.Duration, stop <-chan struct{}, fn func(ctx context.Context)) {
+	app.background(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go func() {
+			select {
+			case <-stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		if interval == 0 {
+			fn(ctx)
+			return
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			fn(ctx)
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+func (app *application) backfillTitleKeys(ctx context.Context) {
+	filled, err := app.models.Movies.BackfillTitleKeys(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			app.logger.Error().Err(err).Msg("failed to backfill movie title keys")
+		}
+		return
+	}
+
+	if filled > 0 {
+		app.logger.Info().Int64("movies", filled).Msg("backfilled movie title keys")
+	}
+}
+
+func (app *application) purgeExpiredTrash(ctx context.Context) {
+	retention := time.Duration(app.config.trash.retentionDays) * 24 * time.Hour
+
+	purged, posterKeys, err := app.models.Movies.PurgeDeletedBefore(time.Now().Add(-retention))
+	if err != nil {
+		app.logger.Error().Err(err).Msg("failed to purge expired trash")
+		return
+	}
+
+	for _, key := range posterKeys {
+		app.deletePoster(ctx, key)
+	}
+
+	if purged > 0 {
+		app.logger.Info().Int64("movies", purged).Msg("purged expired trash")
+	}
+}
+
+func (app *application) purgeExpiredIdempotencyKeys(ctx context.Context) {
+	purged, err := app.models.IdempotencyKeys.DeleteExpired(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			app.logger.Error().Err(err).Msg("failed to purge expired idempotency keys")
+		}
+		return
+	}
+
+	if purged > 0 {
+		app.logger.Info().Int64("keys", purged).Msg("purged expired idempotency keys")
+	}
+}
+
+func (app *application) recomputeSimilarities(ctx context.Context) {
+	start := time.Now()
+
+	stored, err := app.models.Similarities.Recompute(ctx, app.config.similar.weights, app.config.similar.limit)
+	if err != nil {
+		if ctx.Err() == nil {
+			app.logger.Error().Err(err).Msg("failed to recompute similar movies")
+		}
+		return
+	}
+
+	app.logger.Info().Int64("pairs", stored).Dur("took", time.Since(start)).Msg("recomputed similar movies")
+}
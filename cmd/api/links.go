@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"greenlight.swsd2544.net/internal/data"
+)
+
+// paginationRelations are the relation types of the links between pages, in
+// the order they are sent in a Link header.
+var paginationRelations = []string{"first", "prev", "next", "last"}
+
+// forwardedHeaders are the headers a reverse proxy reports the scheme and host
+// the client used in.
+var forwardedHeaders = []string{"Forwarded", "X-Forwarded-Host", "X-Forwarded-Proto"}
+
+// fromTrustedProxy reports whether the request came straight from one of the
+// reverse proxies configured with -trusted-proxies.
+func (app *application) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+
+	for _, prefix := range app.config.trustedProxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// varyForwarded marks responses to requests from trusted proxies as varying
+// on the forwarded headers, which the links in them are built from.
+func (app *application) varyForwarded(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.fromTrustedProxy(r) {
+			for _, name := range forwardedHeaders {
+				w.Header().Add("Vary", name)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// baseURL returns the scheme and host the client reached the API under.
+// Behind a trusted reverse proxy these come from the Forwarded header, or the
+// X-Forwarded-Proto and X-Forwarded-Host headers, as set by the proxy nearest
+// the client; values that aren't a plain scheme or host are ignored. From
+// anyone else those headers could point links at another site, so they are
+// ignored as well.
+func (app *application) baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+
+	if !app.fromTrustedProxy(r) {
+		return scheme + "://" + host
+	}
+
+	var proto, forwardedHost string
+
+	if forwarded := r.Header.Get("Forwarded"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+
+		for _, pair := range strings.Split(first, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			value = strings.Trim(value, `"`)
+
+			switch strings.ToLower(key) {
+			case "proto":
+				proto = value
+			case "host":
+				forwardedHost = value
+			}
+		}
+	} else {
+		proto, _, _ = strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+		forwardedHost, _, _ = strings.Cut(r.Header.Get("X-Forwarded-Host"), ",")
+	}
+
+	proto = strings.ToLower(strings.TrimSpace(proto))
+	if proto == "http" || proto == "https" {
+		scheme = proto
+	}
+
+	forwardedHost = strings.TrimSpace(forwardedHost)
+	if forwardedHost != "" && !strings.ContainsAny(forwardedHost, "/\\?#@<>\"' ") {
+		host = forwardedHost
+	}
+
+	return scheme + "://" + host
+}
+
+// pageLinks returns the links of a paginated list: self for the request
+// itself, and first, prev, next and last for the pages around it, keeping the
+// request's other query string parameters. An empty list has only self.
+func (app *application) pageLinks(r *http.Request, metadata data.Metadata) data.Links {
+	base := app.baseURL(r)
+
+	links := data.Links{"self": {Href: base + r.URL.RequestURI()}}
+
+	if metadata.LastPage == 0 {
+		return links
+	}
+
+	page := func(n int) data.Link {
+		qs := r.URL.Query()
+		qs.Set("page", strconv.Itoa(n))
+		return data.Link{Href: base + r.URL.Path + "?" + qs.Encode()}
+	}
+
+	links["first"] = page(metadata.FirstPage)
+	links["last"] = page(metadata.LastPage)
+
+	if metadata.CurrentPage > metadata.FirstPage {
+		links["prev"] = page(metadata.CurrentPage - 1)
+	}
+
+	if metadata.CurrentPage < metadata.LastPage {
+		links["next"] = page(metadata.CurrentPage + 1)
+	}
+
+	return links
+}
+
+// linkHeader formats the pagination links as an RFC 8288 Link header value.
+func linkHeader(links data.Links) string {
+	var values []string
+
+	for _, rel := range paginationRelations {
+		if link, ok := links[rel]; ok {
+			values = append(values, fmt.Sprintf("<%s>; rel=%q", link.Href, rel))
+		}
+	}
+
+	return strings.Join(values, ", ")
+}
+
+// linkMovies fills in the links of the movies. A movie in the trash links to
+// the trash and to restoring it, as its other resources are gone until then.
+func (app *application) linkMovies(r *http.Request, movies ...*data.Movie) {
+	base := app.baseURL(r)
+
+	for _, movie := range movies {
+		self := fmt.Sprintf("%s/v1/movies/%d", base, movie.ID)
+
+		if movie.DeletedAt != nil {
+			movie.Links = data.Links{
+				"collection": {Href: base + "/v1/movies/trash"},
+				"restore":    {Href: self + "/restore"},
+			}
+			continue
+		}
+
+		movie.Links = data.Links{
+			"self":         {Href: self},
+			"collection":   {Href: base + "/v1/movies"},
+			"credits":      {Href: self + "/credits"},
+			"reviews":      {Href: self + "/reviews"},
+			"translations": {Href: self + "/translations"},
+			"revisions":    {Href: self + "/revisions"},
+			"similar":      {Href: self + "/similar"},
+		}
+
+		if movie.PosterKey != "" || movie.PosterURL != "" {
+			movie.Links["poster"] = data.Link{Href: self + "/poster"}
+		}
+	}
+}
+
+// linkUser fills in the links of the user, who is the one making the request,
+// as only they are ever sent their own account.
+func (app *application) linkUser(r *http.Request, user *data.User) {
+	base := app.baseURL(r)
+
+	user.Links = data.Links{
+		"self":           {Href: base + "/v1/users/me"},
+		"lists":          {Href: base + "/v1/users/me/lists"},
+		"authentication": {Href: base + "/v1/tokens/authentication"},
+	}
+
+	if !user.Activated {
+		user.Links["activation"] = data.Link{Href: base + "/v1/users/activated"}
+	}
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"greenlight.swsd2544.net/internal/data"
+	"greenlight.swsd2544.net/internal/errcode"
+	"greenlight.swsd2544.net/internal/validator"
+)
+
+var (
+	codeListMovieNotFound          = errcode.New("list.movie_id.not_found", "no movie exists with this id")
+	codeListMovieDuplicate         = errcode.New("list.movie_id.duplicate", "this movie is already in the list")
+	codeListClearWatchedOnConflict = errcode.New("list.clear_watched_on.conflict", "must not be set together with watched_on")
+)
+
+// getOwnedList loads the list named in the URL and checks that it belongs to
+// the current user. Lists of other users are reported as not found.
+func (app *application) getOwnedList(w http.ResponseWriter, r *http.Request) (*data.List, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	list, err := app.modelsFor(r).Lists.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if list.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return list, true
+}
+
+func (app *application) readListEntryFilters(qs url.Values, v *validator.Validator) data.Filters {
+	return data.Filters{
+		Page:     app.readInt(qs, "page", 1, v),
+		PageSize: app.readInt(qs, "page_size", 20, v),
+		Sort:     app.readString(qs, "sort", "position"),
+		SortSafeList: []string{
+			"position", "added_at", "watched_on",
+			"-position", "-added_at", "-watched_on",
+		},
+	}
+}
+
+// writeListResponse sends the list along with one page of its entries.
+func (app *application) writeListResponse(w http.ResponseWriter, r *http.Request, list *data.List) {
+	v := validator.New()
+
+	filters := app.readListEntryFilters(r.URL.Query(), v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	entries, metadata, err := app.modelsFor(r).Lists.GetEntries(list.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"list": list, "entries": entries, "metadata": metadata, "_links": app.pageLinks(r, metadata)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listMyListsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "created_at")
+	input.Filters.SortSafeList = []string{"created_at", "name", "-created_at", "-name"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err := app.modelsFor(r).Lists.EnsureDefault(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	lists, metadata, err := app.modelsFor(r).Lists.GetAllForUser(user.ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"lists": lists, "metadata": metadata, "_links": app.pageLinks(r, metadata)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createListHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name   string `json:"name"`
+		Public bool   `json:"public"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	list := &data.List{
+		UserID: app.contextGetUser(r).ID,
+		Name:   input.Name,
+		Public: input.Public,
+	}
+
+	v := validator.New()
+
+	if data.ValidateList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.modelsFor(r).Lists.Insert(list)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/me/lists/%d", list.ID))
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"list": list}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showMyListHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.getOwnedList(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeListResponse(w, r, list)
+}
+
+func (app *application) updateListHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.getOwnedList(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name   *string `json:"name"`
+		Public *bool   `json:"public"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		list.Name = *input.Name
+	}
+	if input.Public != nil {
+		list.Public = *input.Public
+	}
+
+	v := validator.New()
+
+	if data.ValidateList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.modelsFor(r).Lists.Update(list)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteListHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.getOwnedList(w, r)
+	if !ok {
+		return
+	}
+
+	if list.Default {
+		app.badRequestResponse(w, r, errors.New("the default watchlist cannot be deleted"))
+		return
+	}
+
+	err := app.modelsFor(r).Lists.Delete(list.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "list successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) shareListHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.getOwnedList(w, r)
+	if !ok {
+		return
+	}
+
+	token, err := list.NewShareToken()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.modelsFor(r).Lists.Update(list)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"share_token": token,
+		"share_url":   fmt.Sprintf("/v1/shared/lists/%s", token),
+	}
+
+	err = app.writeJSON(w, r, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unshareListHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.getOwnedList(w, r)
+	if !ok {
+		return
+	}
+
+	list.ShareTokenHash = nil
+
+	err := app.modelsFor(r).Lists.Update(list)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "share link successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) addListEntryHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.getOwnedList(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		WatchedOn *data.Date `json:"watched_on"`
+		MovieID   int64      `json:"movie_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	entry := &data.ListEntry{
+		ListID:    list.ID,
+		MovieID:   input.MovieID,
+		WatchedOn: input.WatchedOn,
+	}
+
+	v := validator.New()
+
+	if data.ValidateListEntry(v, entry); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	movie, err := app.modelsFor(r).Movies.Get(entry.MovieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", codeListMovieNotFound)
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	entry.Title = movie.Title
+	entry.Year = movie.Year
+
+	err = app.modelsFor(r).Lists.AddEntry(entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEntry):
+			v.AddError("movie_id", codeListMovieDuplicate)
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateListEntryHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.getOwnedList(w, r)
+	if !ok {
+		return
+	}
+
+	movieID, err := app.readInt64Param(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	entry, err := app.modelsFor(r).Lists.GetEntry(list.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// A watched date that was set by mistake is taken back with
+	// clear_watched_on, as a null watched_on can't be told apart from leaving
+	// it out.
+	var input struct {
+		WatchedOn      *data.Date `json:"watched_on"`
+		Position       *int32     `json:"position"`
+		ClearWatchedOn bool       `json:"clear_watched_on"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(!input.ClearWatchedOn || input.WatchedOn == nil, "clear_watched_on", codeListClearWatchedOnConflict)
+
+	if input.WatchedOn != nil {
+		entry.WatchedOn = input.WatchedOn
+	}
+	if input.ClearWatchedOn {
+		entry.WatchedOn = nil
+	}
+	if input.Position != nil {
+		entry.Position = *input.Position
+	}
+
+	if data.ValidateListEntry(v, entry); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.modelsFor(r).Lists.UpdateEntry(entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeListEntryHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.getOwnedList(w, r)
+	if !ok {
+		return
+	}
+
+	movieID, err := app.readInt64Param(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.modelsFor(r).Lists.RemoveEntry(list.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "movie successfully removed from list"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showPublicListHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	list, err := app.modelsFor(r).Lists.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !list.Public && list.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	app.writeListResponse(w, r, list)
+}
+
+func (app *application) showSharedListHandler(w http.ResponseWriter, r *http.Request) {
+	token := app.readStringParam(r, "token")
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	list, err := app.modelsFor(r).Lists.GetForShareToken(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeListResponse(w, r, list)
+}
@@ -6,6 +6,7 @@ import (
 	"expvar"
 	"flag"
 	"fmt"
+	"net/netip"
 	"os"
 	"runtime"
 	"strings"
@@ -19,6 +20,7 @@ import (
 	"go.opentelemetry.io/otel/sdk/trace"
 	"greenlight.swsd2544.net/internal/data"
 	"greenlight.swsd2544.net/internal/mailer"
+	"greenlight.swsd2544.net/internal/storage"
 	"greenlight.swsd2544.net/internal/vcs"
 )
 
@@ -50,15 +52,38 @@ type config struct {
 		enabled  bool
 		endpoint string
 	}
-	port int
+	trash struct {
+		retentionDays int
+	}
+	storage struct {
+		dir     string
+		baseURL string
+	}
+	posters struct {
+		maxBytes int64
+	}
+	compression struct {
+		minBytes int
+		enabled  bool
+	}
+	similar struct {
+		weights  data.SimilarityWeights
+		interval time.Duration
+		limit    int
+	}
+	trustedProxies []netip.Prefix
+	port           int
+	requireIfMatch bool
+	problemDetails bool
 }
 
 type application struct {
-	wg     sync.WaitGroup
-	models data.Models
-	logger zerolog.Logger
-	mailer mailer.Mailer
-	config config
+	wg      sync.WaitGroup
+	models  data.Models
+	logger  zerolog.Logger
+	mailer  mailer.Mailer
+	storage storage.Storage
+	config  config
 }
 
 func main() {
@@ -81,6 +106,34 @@ func main() {
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.swsd2544.net>", "SMTP sender")
 	flag.BoolVar(&cfg.otlp.enabled, "otlp-enabled", false, "Enable OpenTelemetry")
 	flag.StringVar(&cfg.otlp.endpoint, "otlp-endpoint", "localhost:4317", "OpenTelemetry Collector GRPC endpoint")
+	flag.IntVar(&cfg.trash.retentionDays, "trash-retention-days", 30, "Days before trashed movies are purged (0 keeps them forever)")
+	flag.StringVar(&cfg.storage.dir, "storage-dir", "./uploads", "Directory uploaded files are stored in")
+	flag.StringVar(&cfg.storage.baseURL, "storage-base-url", "/v1/images", "Base URL uploaded files are served from")
+	flag.Int64Var(&cfg.posters.maxBytes, "poster-max-bytes", 10<<20, "Maximum size of a poster upload in bytes")
+	flag.Float64Var(&cfg.similar.weights.Genres, "similar-weight-genres", 0.5, "Weight of genre overlap when ranking similar movies")
+	flag.Float64Var(&cfg.similar.weights.Year, "similar-weight-year", 0.15, "Weight of year proximity when ranking similar movies")
+	flag.Float64Var(&cfg.similar.weights.Credits, "similar-weight-credits", 0.2, "Weight of shared credits when ranking similar movies")
+	flag.Float64Var(&cfg.similar.weights.CoOccurrence, "similar-weight-cooccurrence", 0.15, "Weight of shared audience when ranking similar movies")
+	flag.DurationVar(&cfg.similar.interval, "similar-interval", time.Hour, "Interval between similar movie recomputes (0 disables them)")
+	flag.IntVar(&cfg.similar.limit, "similar-limit", 20, "Number of similar movies stored per movie")
+	flag.BoolVar(&cfg.compression.enabled, "compression-enabled", true, "Compress responses with gzip, brotli or zstd when the client accepts it")
+	flag.IntVar(&cfg.compression.minBytes, "compression-min-bytes", 1024, "Smallest response body in bytes that is compressed")
+	flag.BoolVar(&cfg.requireIfMatch, "require-if-match", false, "Reject movie updates and deletes without an If-Match header")
+	flag.BoolVar(&cfg.problemDetails, "problem-details", false, "Send errors as application/problem+json even to clients that don't ask for it")
+	flag.Func("trusted-proxies", "Reverse proxies whose Forwarded and X-Forwarded-* headers are trusted (space separated IPs or CIDRs)", func(val string) error {
+		for _, field := range strings.Fields(val) {
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil {
+				addr, errAddr := netip.ParseAddr(field)
+				if errAddr != nil {
+					return err
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			cfg.trustedProxies = append(cfg.trustedProxies, prefix)
+		}
+		return nil
+	})
 	flag.Func("cors-trusted-origins", "Trusted CORS origins (space seperated)", func(val string) error {
 		cfg.cors.trustedOrigins = strings.Fields(val)
 		return nil
@@ -97,6 +150,14 @@ func main() {
 
 	logger := zerolog.New(zerolog.NewConsoleWriter()).With().Timestamp().Logger()
 
+	weights := cfg.similar.weights
+	if weights.Genres < 0 || weights.Year < 0 || weights.Credits < 0 || weights.CoOccurrence < 0 {
+		logger.Fatal().Msg("similar movie weights must not be negative")
+	}
+	if cfg.similar.limit < 1 {
+		logger.Fatal().Msg("similar-limit must be at least 1")
+	}
+
 	if cfg.otlp.enabled {
 		exp, err := newOtelCollectorExporter(cfg)
 		if err != nil {
@@ -137,6 +198,11 @@ func main() {
 	models := data.NewModels(db)
 	mailerService := mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender)
 
+	storageService, err := storage.NewLocal(cfg.storage.dir, cfg.storage.baseURL)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to open file storage")
+	}
+
 	expvar.NewString("version").Set(version)
 	expvar.Publish("goroutines", expvar.Func(func() any {
 		return runtime.NumGoroutine()
@@ -149,10 +215,11 @@ func main() {
 	}))
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: models,
-		mailer: mailerService,
+		config:  cfg,
+		logger:  logger,
+		models:  models,
+		mailer:  mailerService,
+		storage: storageService,
 	}
 
 	err = app.serve()
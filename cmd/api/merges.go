@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"greenlight.swsd2544.net/internal/data"
+	"greenlight.swsd2544.net/internal/errcode"
+	"greenlight.swsd2544.net/internal/validator"
+)
+
+var (
+	codeMovieMergeIntoRequired = errcode.New("movie.merge.into.required", "must be provided")
+	codeMovieMergeIntoSame     = errcode.New("movie.merge.into.same_as_source", "must be a different movie")
+)
+
+// redirectMergedMovie answers a request for a movie that no longer exists,
+// redirecting to the movie it was merged into if there is one.
+func (app *application) redirectMergedMovie(w http.ResponseWriter, r *http.Request, id int64) {
+	target, err := app.modelsFor(r).Movies.GetRedirect(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	location := fmt.Sprintf("/v1/movies/%d", target)
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+
+	http.Redirect(w, r, location, http.StatusMovedPermanently)
+}
+
+func (app *application) mergeMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Into int64 `json:"into"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Into > 0, "into", codeMovieMergeIntoRequired)
+	v.Check(input.Into != id, "into", codeMovieMergeIntoSame)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	merge, err := app.modelsFor(r).Movies.Merge(id, input.Into, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if merge.PosterKey != "" {
+		app.deletePoster(r.Context(), merge.PosterKey)
+	}
+
+	movie, err := app.modelsFor(r).Movies.Get(input.Into)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.linkMovies(r, movie)
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movie": movie, "merge": merge}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,20 +1,219 @@
 package main
 
 import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"greenlight.swsd2544.net/internal/data"
+	"greenlight.swsd2544.net/internal/errcode"
+	"greenlight.swsd2544.net/internal/jsonpatch"
 	"greenlight.swsd2544.net/internal/validator"
 )
 
+var movieSortSafeList = []string{
+	"id", "title", "year", "runtime", "rating",
+	"-id", "-title", "-year", "-runtime", "-rating",
+}
+
+var movieIncludeSafeList = []string{"collections", "credits", "review_summary"}
+
+// movieETag derives a strong entity tag from the movie's optimistic-locking
+// version. Review aggregates and the poster change without a new version, so
+// they are part of the tag as well. The tag is that of the compact JSON
+// representation; see representationETag for the others.
+func movieETag(movie *data.Movie) string {
+	if movie.PosterKey != "" {
+		return fmt.Sprintf(`"%d-%d-%d-%.2f-%s"`, movie.ID, movie.Version, movie.Votes, movie.Rating, posterDigest(movie.PosterKey))
+	}
+
+	return fmt.Sprintf(`"%d-%d-%d-%.2f"`, movie.ID, movie.Version, movie.Votes, movie.Rating)
+}
+
+var (
+	codeMovieExternalIDDuplicate = errcode.New("movie.external_ids.duplicate", "an external ID is already used by another movie")
+	codeLookupProviderAmbiguous  = errcode.New("lookup.provider.ambiguous", "must give exactly one external ID to look up")
+	codeExportFormatInvalid      = errcode.New("export.format.invalid", "must be either csv or ndjson")
+	codeExportSortInvalid        = errcode.New("export.sort.invalid", "invalid sort value")
+	codeMovieIncludeInvalid      = errcode.New("movie.include.invalid", "must be one of {values}")
+	codeMovieFieldsInvalid       = errcode.New("movie.fields.invalid", "contains unknown field \"{field}\", must be one of {values}")
+	codeMovieUpsertOnInvalid     = errcode.New("movie.upsert_on.invalid", "must be one of {providers}")
+	codeMovieUpsertOnMissing     = errcode.New("movie.upsert_on.missing", "must include the {provider} ID to upsert on")
+	codeLookupProviderRequired   = errcode.New("lookup.provider.required", "must give one of {providers}")
+)
+
+// checkMovieIfMatch enforces the If-Match precondition on writes to movie. It
+// sends the error response itself and reports whether the handler may carry
+// on.
+func (app *application) checkMovieIfMatch(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	ifMatch := r.Header.Get("If-Match")
+
+	if ifMatch == "" {
+		if app.config.requireIfMatch {
+			app.preconditionRequiredResponse(w, r)
+			return false
+		}
+		return true
+	}
+
+	etag := movieETag(movie)
+
+	etags := []string{etag}
+	for locale := range data.Locales {
+		etags = append(etags, localizedETag(etag, locale))
+	}
+
+	for _, etag := range etags {
+		if etagMatches(ifMatch, etag, true) || etagMatches(ifMatch, prettyETag(etag), true) {
+			return true
+		}
+	}
+
+	app.preconditionFailedResponse(w, r)
+	return false
+}
+
+// localizedETag returns the strong tag of a movie representation served to a
+// client preferring locale that the movie has no translation for. Those are
+// the same movie as the untranslated one, but are told apart by caches as
+// they vary on Accept-Language.
+func localizedETag(etag, locale string) string {
+	if locale == "" {
+		return etag
+	}
+
+	return strings.TrimSuffix(etag, `"`) + "-" + locale + `"`
+}
+
+// localized reports whether Localize translated any of the movies.
+func localized(movies ...*data.Movie) bool {
+	for _, movie := range movies {
+		if movie.OriginalTitle != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readMovieCriteria reads the movie search parameters shared by the list and
+// export endpoints. Genre filters may use any alias and are matched on the
+// canonical slug.
+func (app *application) readMovieCriteria(r *http.Request, qs url.Values) (data.MovieCriteria, error) {
+	genres, err := app.modelsFor(r).Genres.Catalog()
+	if err != nil {
+		return data.MovieCriteria{}, err
+	}
+
+	return data.MovieCriteria{
+		Title:    app.readString(qs, "title", ""),
+		Genres:   genres.CanonicalAll(app.readCSV(qs, "genres", []string{})),
+		Director: app.readString(qs, "director", ""),
+		Actor:    app.readString(qs, "actor", ""),
+	}, nil
+}
+
+// readMovieIncludes reads the related resources to embed in movie responses
+// from the include parameter.
+func (app *application) readMovieIncludes(qs url.Values, v *validator.Validator) []string {
+	includes := app.readCSV(qs, "include", []string{})
+
+	for _, include := range includes {
+		v.Check(validator.PermittedValue(include, movieIncludeSafeList...), "include", codeMovieIncludeInvalid, errcode.Params{"values": movieIncludeSafeList})
+	}
+
+	return includes
+}
+
+// readMovieFields reads the sparse fieldset from the fields parameter. No
+// fields means the full movie.
+func (app *application) readMovieFields(qs url.Values, v *validator.Validator) []string {
+	fields := app.readCSV(qs, "fields", []string{})
+
+	for _, field := range fields {
+		v.Check(validator.PermittedValue(field, data.MovieFields...), "fields", codeMovieFieldsInvalid, errcode.Params{"field": field, "values": data.MovieFields})
+	}
+
+	return fields
+}
+
+// embedInMovies loads the requested related resources into the movies. Each
+// include is a single query however many movies there are.
+func (app *application) embedInMovies(r *http.Request, includes []string, movies ...*data.Movie) error {
+	for _, include := range includes {
+		var err error
+
+		switch include {
+		case "collections":
+			err = app.modelsFor(r).Collections.EmbedInMovies(movies...)
+		case "credits":
+			err = app.modelsFor(r).Credits.EmbedInMovies(movies...)
+		case "review_summary":
+			err = app.modelsFor(r).Reviews.EmbedInMovies(movies...)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sparseMovies trims the JSON representation of the movies down to the
+// selected fields, the embedded includes and the links, keeping their order.
+// Without a sparse fieldset the movies are returned as they are.
+func sparseMovies(fields, includes []string, movies ...*data.Movie) ([]any, error) {
+	sparse := make([]any, len(movies))
+
+	for i, movie := range movies {
+		if len(fields) == 0 {
+			sparse[i] = movie
+			continue
+		}
+
+		js, err := json.Marshal(movie)
+		if err != nil {
+			return nil, err
+		}
+
+		var all map[string]json.RawMessage
+
+		err = json.Unmarshal(js, &all)
+		if err != nil {
+			return nil, err
+		}
+
+		selected := make(map[string]json.RawMessage, len(fields)+len(includes))
+
+		for _, keys := range [][]string{fields, includes, {"_links"}} {
+			for _, key := range keys {
+				if value, ok := all[key]; ok {
+					selected[key] = value
+				}
+			}
+		}
+
+		sparse[i] = selected
+	}
+
+	return sparse, nil
+}
+
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title   string       `json:"title"`
-		Genres  []string     `json:"genres"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
+		Title       string           `json:"title"`
+		Genres      []string         `json:"genres"`
+		ExternalIDs data.ExternalIDs `json:"external_ids"`
+		Year        int32            `json:"year"`
+		Runtime     data.Runtime     `json:"runtime"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -24,29 +223,171 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:       input.Title,
+		Year:        input.Year,
+		Runtime:     input.Runtime,
+		Genres:      input.Genres,
+		ExternalIDs: input.ExternalIDs,
+	}
+
+	genres, err := app.modelsFor(r).Genres.Catalog()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+	qs := r.URL.Query()
+
+	force := app.readBool(qs, "force", false, v)
+	upsertOn := app.readString(qs, "upsert_on", "")
+
+	if upsertOn != "" {
+		_, ok := data.ExternalProviders[upsertOn]
+		v.Check(ok, "upsert_on", codeMovieUpsertOnInvalid, errcode.Params{"providers": data.SupportedExternalProviders()})
+		v.Check(movie.ExternalIDs[upsertOn] != "", "external_ids", codeMovieUpsertOnMissing, errcode.Params{"provider": upsertOn})
+	}
+
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	if upsertOn != "" {
+		existing, err := app.modelsFor(r).Movies.GetByExternalID(upsertOn, movie.ExternalIDs[upsertOn])
+		switch {
+		case err == nil:
+			app.upsertMovie(w, r, existing, movie)
+			return
+		case !errors.Is(err, data.ErrRecordNotFound):
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.modelsFor(r).Movies.Insert(movie, force)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateMovie):
+			duplicates, err := app.modelsFor(r).Movies.FindDuplicates(movie)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			app.duplicateMovieResponse(w, r, duplicates)
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_ids", codeMovieExternalIDDuplicate)
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
+	app.linkMovies(r, movie)
+
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// upsertMovie overwrites an existing movie, found by external ID, with the
+// fields of a validated create request. External IDs are merged rather than
+// replaced, so an ingestion source that only knows some providers doesn't
+// drop the others.
+func (app *application) upsertMovie(w http.ResponseWriter, r *http.Request, existing, movie *data.Movie) {
+	existing.Title = movie.Title
+	existing.Year = movie.Year
+	existing.Runtime = movie.Runtime
+	existing.Genres = movie.Genres
+
+	if existing.ExternalIDs == nil {
+		existing.ExternalIDs = make(data.ExternalIDs)
+	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	for provider, id := range movie.ExternalIDs {
+		existing.ExternalIDs[provider] = id
+	}
+
+	err := app.modelsFor(r).Movies.Update(existing)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v := validator.New()
+			v.AddError("external_ids", codeMovieExternalIDDuplicate)
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.linkMovies(r, existing)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", existing.ID))
+	headers.Set("ETag", movieETag(existing))
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movie": existing}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// lookupMovieHandler finds a movie by its identifier at an external
+// provider, given as the single query parameter, e.g. ?imdb=tt0111161.
+func (app *application) lookupMovieHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	v := validator.New()
+
+	var provider, id string
+
+	for key := range qs {
+		if provider != "" {
+			v.AddError("provider", codeLookupProviderAmbiguous)
+			break
+		}
+		provider, id = key, qs.Get(key)
+	}
+
+	v.Check(provider != "", "provider", codeLookupProviderRequired, errcode.Params{"providers": data.SupportedExternalProviders()})
+
+	if v.Valid() {
+		data.ValidateExternalID(v, provider, provider, id)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	movie, err := app.modelsFor(r).Movies.GetByExternalID(provider, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.linkMovies(r, movie)
+
+	headers := make(http.Header)
+	headers.Set("Content-Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeConditionalJSON(w, r, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -59,18 +400,70 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	v := validator.New()
+
+	locale := app.readLocale(r, v)
+	includes := app.readMovieIncludes(r.URL.Query(), v)
+	fields := app.readMovieFields(r.URL.Query(), v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	movie, err := app.modelsFor(r).Movies.Get(id, fields...)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
+			app.redirectMergedMovie(w, r, id)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	w.Header().Add("Vary", "Accept-Language")
+
+	err = app.modelsFor(r).MovieTranslations.Localize(locale, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+
+	if localized(movie) {
+		headers.Set("Content-Language", locale)
+	}
+
+	// Translations and embedded resources change without a new movie
+	// version, so those representations are tagged with a weak ETag computed
+	// from the body rather than the version-based one used for If-Match. So
+	// are sparse fieldsets, which may not read what the strong tag is made of,
+	// and explicitly requested languages. A language merely preferred in
+	// Accept-Language that the movie has no translation for leaves the movie
+	// as it is, under a strong tag of its own.
+	explicit := r.URL.Query().Get("lang") != ""
+
+	if !explicit && !localized(movie) && len(includes) == 0 && len(fields) == 0 {
+		headers.Set("ETag", localizedETag(movieETag(movie), locale))
+	}
+
+	err = app.embedInMovies(r, includes, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.linkMovies(r, movie)
+
+	sparse, err := sparseMovies(fields, includes, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeConditionalJSON(w, r, http.StatusOK, envelope{"movie": sparse[0]}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -83,7 +476,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.modelsFor(r).Movies.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -94,56 +487,173 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+	if !app.checkMovieIfMatch(w, r, movie) {
+		return
 	}
 
-	err = app.readJSON(w, r, &input)
+	mediaType, err := app.readMediaType(r)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.unsupportedMediaTypeResponse(w, r)
 		return
 	}
 
-	if input.Title != nil {
-		movie.Title = *input.Title
-	}
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
+	switch mediaType {
+	case "application/merge-patch+json", "application/json-patch+json":
+		err = app.patchMovie(w, r, mediaType, movie)
+		if err != nil {
+			switch {
+			case errors.Is(err, jsonpatch.ErrTestFailed):
+				app.patchTestFailedResponse(w, r)
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+	default:
+		var input struct {
+			Title       *string          `json:"title"`
+			Year        *int32           `json:"year"`
+			Runtime     *data.Runtime    `json:"runtime"`
+			Genres      []string         `json:"genres"`
+			ExternalIDs data.ExternalIDs `json:"external_ids"`
+		}
+
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		if input.Title != nil {
+			movie.Title = *input.Title
+		}
+		if input.Year != nil {
+			movie.Year = *input.Year
+		}
+		if input.Runtime != nil {
+			movie.Runtime = *input.Runtime
+		}
+		if input.Genres != nil {
+			movie.Genres = input.Genres
+		}
+		if input.ExternalIDs != nil {
+			movie.ExternalIDs = input.ExternalIDs
+		}
 	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres
+
+	genres, err := app.modelsFor(r).Genres.Catalog()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	err = app.modelsFor(r).Movies.Update(movie)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_ids", codeMovieExternalIDDuplicate)
+			app.failedValidationResponse(w, r, v)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, nil)
+	app.linkMovies(r, movie)
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// patchMovie applies a JSON Merge Patch or JSON Patch request body to the
+// movie's JSON representation and copies the result back onto movie. The id,
+// version, rating and votes members may be tested but not changed.
+func (app *application) patchMovie(w http.ResponseWriter, r *http.Request, mediaType string, movie *data.Movie) error {
+	doc, err := json.Marshal(movie)
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+
+	switch mediaType {
+	case "application/merge-patch+json":
+		var patch json.RawMessage
+
+		err = app.readJSON(w, r, &patch)
+		if err != nil {
+			return err
+		}
+
+		patched, err = jsonpatch.Merge(doc, patch)
+	default:
+		var ops []jsonpatch.Operation
+
+		err = app.readJSON(w, r, &ops)
+		if err != nil {
+			return err
+		}
+
+		patched, err = jsonpatch.Apply(doc, ops)
+	}
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Title       string           `json:"title"`
+		PosterURL   string           `json:"poster_url"`
+		Genres      []string         `json:"genres"`
+		ExternalIDs data.ExternalIDs `json:"external_ids"`
+		ID          int64            `json:"id"`
+		Rating      float64          `json:"rating"`
+		Year        int32            `json:"year"`
+		Runtime     data.Runtime     `json:"runtime"`
+		Votes       int32            `json:"votes"`
+		Version     int32            `json:"version"`
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&result)
+	if err != nil {
+		return jsonDecodeError(err)
+	}
+
+	if result.ID != movie.ID || result.Version != movie.Version || result.Rating != movie.Rating || result.Votes != movie.Votes {
+		return errors.New("patch must not change the id, version, rating or votes of the movie")
+	}
+
+	if result.PosterURL != movie.PosterURL {
+		return errors.New("patch must not change the poster_url of the movie, upload a new poster instead")
+	}
+
+	movie.Title = result.Title
+	movie.Genres = result.Genres
+	movie.ExternalIDs = result.ExternalIDs
+	movie.Year = result.Year
+	movie.Runtime = result.Runtime
+
+	return nil
+}
+
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -151,7 +661,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	movie, err := app.modelsFor(r).Movies.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -162,7 +672,24 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	if !app.checkMovieIfMatch(w, r, movie) {
+		return
+	}
+
+	err = app.modelsFor(r).Movies.DeleteVersion(movie.ID, movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -170,8 +697,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieCriteria
 		data.Filters
 	}
 
@@ -179,29 +705,294 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 
 	qs := r.URL.Query()
 
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	criteria, err := app.readMovieCriteria(r, qs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	input.MovieCriteria = criteria
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafeList = []string{
-		"id", "title", "year",
-		"runtime", "-id", "-title", "-year", "-runtime",
+	input.Filters.SortSafeList = movieSortSafeList
+
+	locale := app.readLocale(r, v)
+	includes := app.readMovieIncludes(qs, v)
+	fields := app.readMovieFields(qs, v)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	movies, metadata, err := app.modelsFor(r).Movies.GetAll(input.MovieCriteria, input.Filters, fields...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.modelsFor(r).MovieTranslations.Localize(locale, movies...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !localized(movies...) {
+		locale = ""
+	}
+
+	err = app.embedInMovies(r, includes, movies...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
+	app.linkMovies(r, movies...)
+
+	sparse, err := sparseMovies(fields, includes, movies...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Language")
+
+	links := app.pageLinks(r, metadata)
+
+	headers := make(http.Header)
+	if link := linkHeader(links); link != "" {
+		headers.Set("Link", link)
+	}
+	if locale != "" {
+		headers.Set("Content-Language", locale)
+	}
+
+	env := envelope{"movies": sparse, "metadata": metadata, "_links": links}
+
+	err = app.writeConditionalJSON(w, r, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listTrashedMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.SortSafeList = append([]string{"deleted_at", "-deleted_at"}, movieSortSafeList...)
+
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	movies, metadata, err := app.modelsFor(r).Movies.GetAllDeleted(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	app.linkMovies(r, movies...)
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movies": movies, "metadata": metadata, "_links": app.pageLinks(r, metadata)}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.modelsFor(r).Movies.Restore(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v := validator.New()
+			v.AddError("external_ids", codeMovieExternalIDDuplicate)
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.linkMovies(r, movie)
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) purgeMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	posterKey, err := app.modelsFor(r).Movies.Purge(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if posterKey != "" {
+		app.deletePoster(r.Context(), posterKey)
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "movie permanently deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Format        string
+		RuntimeFormat string
+		data.MovieCriteria
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	criteria, err := app.readMovieCriteria(r, qs)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	input.MovieCriteria = criteria
+	input.Format = app.readString(qs, "format", "ndjson")
+	input.RuntimeFormat = app.readString(qs, "runtime_format", data.RuntimeFormatMins)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafeList = movieSortSafeList
+
+	v.Check(validator.PermittedValue(input.Format, "csv", "ndjson"), "format", codeExportFormatInvalid)
+	v.Check(validator.PermittedValue(input.Filters.Sort, input.Filters.SortSafeList...), "sort", codeExportSortInvalid)
+	data.ValidateRuntimeFormat(v, input.RuntimeFormat)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Exports can easily outlive the server's write timeout, so lift it for
+	// this response only. Writers that don't support it, such as a batch
+	// sub-response, have no timeout to lift.
+	rc := http.NewResponseController(w)
+	err = rc.SetWriteDeadline(time.Time{})
+	switch {
+	case errors.Is(err, http.ErrNotSupported):
+		app.logError(r, err)
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var write func(*data.Movie) error
+	var flush func() error
+
+	switch input.Format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"id", "title", "year", "runtime", "genres", "version"})
+
+		write = func(movie *data.Movie) error {
+			return cw.Write([]string{
+				strconv.FormatInt(movie.ID, 10),
+				movie.Title,
+				strconv.FormatInt(int64(movie.Year), 10),
+				movie.Runtime.Format(input.RuntimeFormat),
+				strings.Join(movie.Genres, "|"),
+				strconv.FormatInt(int64(movie.Version), 10),
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.csv"`)
+	default:
+		enc := json.NewEncoder(w)
+
+		write = func(movie *data.Movie) error {
+			var runtime any = movie.Runtime.Format(input.RuntimeFormat)
+			if input.RuntimeFormat == data.RuntimeFormatMinutes {
+				runtime = int32(movie.Runtime)
+			}
+
+			return enc.Encode(struct {
+				ID      int64    `json:"id"`
+				Title   string   `json:"title"`
+				Year    int32    `json:"year"`
+				Runtime any      `json:"runtime"`
+				Genres  []string `json:"genres"`
+				Version int32    `json:"version"`
+			}{movie.ID, movie.Title, movie.Year, runtime, movie.Genres, movie.Version})
+		}
+		flush = func() error { return nil }
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+
+	// Headers can't be changed once the first row is out, so any failure
+	// after that point is only logged and the stream is cut short.
+	written := 0
+
+	err = app.modelsFor(r).Movies.Export(r.Context(), input.MovieCriteria, input.Filters, func(movie *data.Movie) error {
+		if err := write(movie); err != nil {
+			return err
+		}
+
+		written++
+		if written%100 == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		if written == 0 {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.logError(r, err)
 	}
 }
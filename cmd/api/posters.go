@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"greenlight.swsd2544.net/internal/data"
+	"greenlight.swsd2544.net/internal/errcode"
+	"greenlight.swsd2544.net/internal/storage"
+	"greenlight.swsd2544.net/internal/validator"
+)
+
+const (
+	posterMaxDimension = 6000
+	posterMinDimension = 100
+	// posterMemoryLimit is how much of a multipart upload is buffered in
+	// memory before the rest spills to a temporary file.
+	posterMemoryLimit = 1 << 20
+)
+
+// posterContentTypes maps the sniffed content types accepted for posters to
+// the extension the original is stored under.
+var posterContentTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// posterThumbnails are the widths thumbnails are generated at, keyed by the
+// size name clients ask for.
+var posterThumbnails = map[string]int{
+	"w92":  92,
+	"w342": 342,
+}
+
+var posterSizes = []string{"original", "w92", "w342"}
+
+type posterUpload struct {
+	image       image.Image
+	file        multipart.File
+	contentType string
+	extension   string
+	digest      string
+}
+
+// posterDigest returns the content digest embedded in a poster's storage key,
+// "posters/<movie id>/<digest>/original.<ext>".
+func posterDigest(key string) string {
+	return path.Base(path.Dir(key))
+}
+
+// posterSizeKey returns the storage key of the named size of a poster.
+func posterSizeKey(key, size string) string {
+	if size == "original" {
+		return key
+	}
+
+	return path.Dir(key) + "/" + size + ".jpg"
+}
+
+var (
+	codePosterTypeUnsupported = errcode.New("poster.type.unsupported", "must be a JPEG, PNG or WebP image")
+	codePosterInvalid         = errcode.New("poster.invalid", "must be a valid image")
+	codePosterRequired        = errcode.New("poster.required", "must be provided")
+	codePosterTooLarge        = errcode.New("poster.dimensions.too_large", "must not be larger than {max}x{max} pixels")
+	codePosterTooSmall        = errcode.New("poster.dimensions.too_small", "must be at least {min}x{min} pixels")
+	codePosterSizeInvalid     = errcode.New("poster.size.invalid", "must be one of {values}")
+)
+
+// readPoster sniffs, measures and decodes an uploaded poster, recording any
+// problems with the file in v.
+func (app *application) readPoster(file multipart.File, v *validator.Validator) (*posterUpload, error) {
+	head := make([]byte, 512)
+
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	contentType := http.DetectContentType(head[:n])
+
+	extension, ok := posterContentTypes[contentType]
+	if v.Check(ok, "poster", codePosterTypeUnsupported); !v.Valid() {
+		return nil, nil
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the dimensions before decoding, so a small file that claims to
+	// be an enormous image can't exhaust memory.
+	config, _, err := image.DecodeConfig(file)
+	if v.Check(err == nil, "poster", codePosterInvalid); !v.Valid() {
+		return nil, nil
+	}
+
+	v.Check(config.Width <= posterMaxDimension && config.Height <= posterMaxDimension, "poster", codePosterTooLarge, errcode.Params{"max": posterMaxDimension})
+	v.Check(config.Width >= posterMinDimension && config.Height >= posterMinDimension, "poster", codePosterTooSmall, errcode.Params{"min": posterMinDimension})
+
+	if !v.Valid() {
+		return nil, nil
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(file)
+	if v.Check(err == nil, "poster", codePosterInvalid); !v.Valid() {
+		return nil, nil
+	}
+
+	return &posterUpload{
+		image:       img,
+		file:        file,
+		contentType: contentType,
+		extension:   extension,
+		digest:      hex.EncodeToString(hash.Sum(nil))[:16],
+	}, nil
+}
+
+// storePoster writes the original upload and its thumbnails to storage and
+// returns the original's key.
+func (app *application) storePoster(ctx context.Context, movieID int64, poster *posterUpload) (string, error) {
+	key := fmt.Sprintf("posters/%d/%s/original%s", movieID, poster.digest, poster.extension)
+
+	_, err := poster.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	err = app.storage.Put(ctx, key, poster.file, poster.contentType)
+	if err != nil {
+		return "", err
+	}
+
+	bounds := poster.image.Bounds()
+
+	for size, width := range posterThumbnails {
+		// Never upscale; a small original is stored at its own size.
+		if width > bounds.Dx() {
+			width = bounds.Dx()
+		}
+		height := bounds.Dy() * width / bounds.Dx()
+
+		thumbnail := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(thumbnail, thumbnail.Bounds(), poster.image, bounds, draw.Src, nil)
+
+		var buf bytes.Buffer
+
+		err = jpeg.Encode(&buf, thumbnail, &jpeg.Options{Quality: 85})
+		if err != nil {
+			return "", err
+		}
+
+		err = app.storage.Put(ctx, posterSizeKey(key, size), &buf, "image/jpeg")
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return key, nil
+}
+
+// deletePoster removes every size of a poster from storage. Failures are only
+// logged: the movie no longer references the files, so at worst they linger.
+func (app *application) deletePoster(ctx context.Context, key string) {
+	for _, size := range posterSizes {
+		err := app.storage.Delete(ctx, posterSizeKey(key, size))
+		if err != nil {
+			app.logger.Error().Err(err).Str("key", key).Msg("failed to delete poster")
+		}
+	}
+}
+
+func (app *application) uploadMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.modelsFor(r).Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Posters are far larger than the JSON bodies readJSON allows, so this
+	// route enforces a limit of its own.
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.posters.maxBytes)
+
+	err = r.ParseMultipartForm(posterMemoryLimit)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.contentTooLargeResponse(w, r, maxBytesError.Limit)
+		case errors.Is(err, http.ErrNotMultipart):
+			app.unsupportedMediaTypeResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	defer func() {
+		_ = r.MultipartForm.RemoveAll()
+	}()
+
+	v := validator.New()
+
+	file, _, err := r.FormFile("poster")
+	if err != nil {
+		switch {
+		case errors.Is(err, http.ErrMissingFile):
+			v.AddError("poster", codePosterRequired)
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	poster, err := app.readPoster(file, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	key, err := app.storePoster(r.Context(), movie.ID, poster)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	previousKey := movie.PosterKey
+
+	movie.PosterKey = key
+	movie.PosterURL = app.storage.URL(key)
+
+	err = app.modelsFor(r).Movies.UpdatePoster(movie)
+	if err != nil {
+		app.deletePoster(r.Context(), key)
+
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if previousKey != "" && previousKey != key {
+		app.deletePoster(r.Context(), previousKey)
+	}
+
+	app.linkMovies(r, movie)
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showMoviePosterHandler redirects to the requested size of the movie's
+// poster, so clients don't need to know how sizes are laid out in storage.
+func (app *application) showMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	size := app.readString(r.URL.Query(), "size", "original")
+
+	v := validator.New()
+
+	if v.Check(validator.PermittedValue(size, posterSizes...), "size", codePosterSizeInvalid, errcode.Params{"values": posterSizes}); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	movie, err := app.modelsFor(r).Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if movie.PosterKey == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	http.Redirect(w, r, app.storage.URL(posterSizeKey(movie.PosterKey, size)), http.StatusFound)
+}
+
+func (app *application) deleteMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.modelsFor(r).Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if movie.PosterKey == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	previousKey := movie.PosterKey
+
+	movie.PosterKey = ""
+	movie.PosterURL = ""
+
+	err = app.modelsFor(r).Movies.UpdatePoster(movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.deletePoster(r.Context(), previousKey)
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "poster successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// serveImageHandler serves uploaded images from storage. Image keys embed a
+// digest of their content, so a URL always refers to the same bytes and the
+// response can be cached for good.
+func (app *application) serveImageHandler(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(app.readStringParam(r, "key"), "/")
+
+	object, err := app.storage.Open(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrInvalidKey):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	defer func() {
+		_ = object.Close()
+	}()
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if object.ContentType != "" {
+		w.Header().Set("Content-Type", object.ContentType)
+	}
+
+	http.ServeContent(w, r, "", object.ModTime, object)
+}
@@ -14,20 +14,107 @@ func (app *application) routes() http.Handler {
 	router.NotFound = otelhttp.NewHandler(http.HandlerFunc(app.notFoundResponse), "notFound")
 	router.MethodNotAllowed = otelhttp.NewHandler(http.HandlerFunc(app.methodNotAllowedResponse), "methodNotAllowed")
 
+	// Batched sub-requests go through authentication and the routes, but
+	// not the middleware around them such as rate limiting, which already
+	// counted the batch itself.
+	var dispatch http.Handler
+	subrequests := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dispatch.ServeHTTP(w, r)
+	})
+
 	router.Handler(http.MethodGet, "/v1/healthcheck", otelhttp.NewHandler(http.HandlerFunc(app.healthcheckHandler), "healthcheck"))
+	router.Handler(http.MethodPost, "/v1/batch", otelhttp.NewHandler(app.requireActivatedUser(app.batchHandler(subrequests)), "batch"))
+	router.Handler(http.MethodGet, "/v1/errors", otelhttp.NewHandler(http.HandlerFunc(app.listErrorCodesHandler), "listErrorCodes"))
 
 	router.Handler(http.MethodGet, "/v1/movies", otelhttp.NewHandler(app.requirePermission("movies:read", app.listMoviesHandler), "listMovies"))
-	router.Handler(http.MethodPost, "/v1/movies", otelhttp.NewHandler(app.requirePermission("movies:write", app.createMovieHandler), "createMovie"))
+	router.Handler(http.MethodPost, "/v1/movies", otelhttp.NewHandler(app.requirePermission("movies:write", app.idempotent(app.createMovieHandler)), "createMovie"))
 	router.Handler(http.MethodGet, "/v1/movies/:id", otelhttp.NewHandler(app.requirePermission("movies:read", app.showMovieHandler), "showMovie"))
 	router.Handler(http.MethodPatch, "/v1/movies/:id", otelhttp.NewHandler(app.requirePermission("movies:write", app.updateMovieHandler), "updateMovie"))
 	router.Handler(http.MethodDelete, "/v1/movies/:id", otelhttp.NewHandler(app.requirePermission("movies:write", app.deleteMovieHandler), "deleteMovie"))
+	router.Handler(http.MethodPost, "/v1/movies/:id/restore", otelhttp.NewHandler(app.requirePermission("movies:write", app.restoreMovieHandler), "restoreMovie"))
+	router.Handler(http.MethodGet, "/v1/movies/:id/revisions", otelhttp.NewHandler(app.requirePermission("movies:read", app.listMovieRevisionsHandler), "listMovieRevisions"))
+	router.Handler(http.MethodGet, "/v1/movies/:id/revisions/:version", otelhttp.NewHandler(app.requirePermission("movies:read", app.showMovieRevisionHandler), "showMovieRevision"))
+	router.Handler(http.MethodPost, "/v1/movies/:id/revisions/:version/restore", otelhttp.NewHandler(app.requirePermission("movies:write", app.restoreMovieRevisionHandler), "restoreMovieRevision"))
+	router.Handler(http.MethodGet, "/v1/movies/:id/similar", otelhttp.NewHandler(app.requirePermission("movies:read", app.similarMoviesHandler), "similarMovies"))
+	router.Handler(http.MethodGet, "/v1/movies/:id/credits", otelhttp.NewHandler(app.requirePermission("movies:read", app.listMovieCreditsHandler), "listMovieCredits"))
+	router.Handler(http.MethodPost, "/v1/movies/:id/credits", otelhttp.NewHandler(app.requirePermission("movies:write", app.createMovieCreditHandler), "createMovieCredit"))
+	router.Handler(http.MethodPatch, "/v1/movies/:id/credits/:credit_id", otelhttp.NewHandler(app.requirePermission("movies:write", app.updateMovieCreditHandler), "updateMovieCredit"))
+	router.Handler(http.MethodDelete, "/v1/movies/:id/credits/:credit_id", otelhttp.NewHandler(app.requirePermission("movies:write", app.deleteMovieCreditHandler), "deleteMovieCredit"))
+	router.Handler(http.MethodGet, "/v1/movies/:id/reviews", otelhttp.NewHandler(app.requirePermission("movies:read", app.listMovieReviewsHandler), "listMovieReviews"))
+	router.Handler(http.MethodPost, "/v1/movies/:id/reviews", otelhttp.NewHandler(app.requireActivatedUser(app.createMovieReviewHandler), "createMovieReview"))
+	router.Handler(http.MethodPatch, "/v1/movies/:id/reviews/:review_id", otelhttp.NewHandler(app.requireActivatedUser(app.updateMovieReviewHandler), "updateMovieReview"))
+	router.Handler(http.MethodDelete, "/v1/movies/:id/reviews/:review_id", otelhttp.NewHandler(app.requireActivatedUser(app.deleteMovieReviewHandler), "deleteMovieReview"))
+	router.Handler(http.MethodPut, "/v1/movies/:id/reviews/:review_id/status", otelhttp.NewHandler(app.requirePermission("reviews:moderate", app.moderateMovieReviewHandler), "moderateMovieReview"))
+	router.Handler(http.MethodGet, "/v1/movies/:id/translations", otelhttp.NewHandler(app.requirePermission("movies:read", app.listMovieTranslationsHandler), "listMovieTranslations"))
+	router.Handler(http.MethodPut, "/v1/movies/:id/translations/:locale", otelhttp.NewHandler(app.requirePermission("movies:write", app.putMovieTranslationHandler), "putMovieTranslation"))
+	router.Handler(http.MethodDelete, "/v1/movies/:id/translations/:locale", otelhttp.NewHandler(app.requirePermission("movies:write", app.deleteMovieTranslationHandler), "deleteMovieTranslation"))
+	router.Handler(http.MethodGet, "/v1/movies/:id/poster", otelhttp.NewHandler(app.requirePermission("movies:read", app.showMoviePosterHandler), "showMoviePoster"))
+	router.Handler(http.MethodPut, "/v1/movies/:id/poster", otelhttp.NewHandler(app.requirePermission("movies:write", app.uploadMoviePosterHandler), "uploadMoviePoster"))
+	router.Handler(http.MethodDelete, "/v1/movies/:id/poster", otelhttp.NewHandler(app.requirePermission("movies:write", app.deleteMoviePosterHandler), "deleteMoviePoster"))
+	router.Handler(http.MethodGet, "/v1/movies/:id/diff", otelhttp.NewHandler(app.requirePermission("movies:read", app.diffMovieRevisionsHandler), "diffMovieRevisions"))
+
+	router.Handler(http.MethodPost, "/v1/admin/movies/:id/merge", otelhttp.NewHandler(app.requirePermission("movies:merge", app.mergeMovieHandler), "mergeMovie"))
+
+	router.Handler(http.MethodGet, "/v1/collections", otelhttp.NewHandler(http.HandlerFunc(app.listCollectionsHandler), "listCollections"))
+	router.Handler(http.MethodPost, "/v1/collections", otelhttp.NewHandler(app.requirePermission("collections:write", app.createCollectionHandler), "createCollection"))
+	router.Handler(http.MethodGet, "/v1/collections/:slug", otelhttp.NewHandler(http.HandlerFunc(app.showCollectionHandler), "showCollection"))
+	router.Handler(http.MethodPatch, "/v1/collections/:slug", otelhttp.NewHandler(app.requirePermission("collections:write", app.updateCollectionHandler), "updateCollection"))
+	router.Handler(http.MethodDelete, "/v1/collections/:slug", otelhttp.NewHandler(app.requirePermission("collections:write", app.deleteCollectionHandler), "deleteCollection"))
+	router.Handler(http.MethodPost, "/v1/collections/:slug/movies", otelhttp.NewHandler(app.requirePermission("collections:write", app.addCollectionMovieHandler), "addCollectionMovie"))
+	router.Handler(http.MethodPatch, "/v1/collections/:slug/movies/:movie_id", otelhttp.NewHandler(app.requirePermission("collections:write", app.updateCollectionMovieHandler), "updateCollectionMovie"))
+	router.Handler(http.MethodDelete, "/v1/collections/:slug/movies/:movie_id", otelhttp.NewHandler(app.requirePermission("collections:write", app.removeCollectionMovieHandler), "removeCollectionMovie"))
 
-	router.Handler(http.MethodPost, "/v1/users", otelhttp.NewHandler(http.HandlerFunc(app.registerUserHandler), "registerUser"))
+	router.Handler(http.MethodGet, "/v1/genres", otelhttp.NewHandler(app.requirePermission("movies:read", app.listGenresHandler), "listGenres"))
+	router.Handler(http.MethodPost, "/v1/genres", otelhttp.NewHandler(app.requirePermission("genres:write", app.createGenreHandler), "createGenre"))
+	router.Handler(http.MethodPatch, "/v1/genres/:slug", otelhttp.NewHandler(app.requirePermission("genres:write", app.updateGenreHandler), "updateGenre"))
+	router.Handler(http.MethodPost, "/v1/admin/genres/merge", otelhttp.NewHandler(app.requirePermission("genres:write", app.mergeGenresHandler), "mergeGenres"))
+
+	router.Handler(http.MethodGet, "/v1/people", otelhttp.NewHandler(app.requirePermission("movies:read", app.listPeopleHandler), "listPeople"))
+	router.Handler(http.MethodPost, "/v1/people", otelhttp.NewHandler(app.requirePermission("movies:write", app.createPersonHandler), "createPerson"))
+	router.Handler(http.MethodGet, "/v1/people/:id", otelhttp.NewHandler(app.requirePermission("movies:read", app.showPersonHandler), "showPerson"))
+	router.Handler(http.MethodPatch, "/v1/people/:id", otelhttp.NewHandler(app.requirePermission("movies:write", app.updatePersonHandler), "updatePerson"))
+	router.Handler(http.MethodDelete, "/v1/people/:id", otelhttp.NewHandler(app.requirePermission("movies:write", app.deletePersonHandler), "deletePerson"))
+	router.Handler(http.MethodGet, "/v1/people/:id/filmography", otelhttp.NewHandler(app.requirePermission("movies:read", app.showFilmographyHandler), "showFilmography"))
+
+	router.Handler(http.MethodPost, "/v1/users", otelhttp.NewHandler(app.idempotent(app.registerUserHandler), "registerUser"))
 	router.Handler(http.MethodPut, "/v1/users/activated", otelhttp.NewHandler(http.HandlerFunc(app.activateUserHandler), "activateUser"))
+	router.Handler(http.MethodGet, "/v1/users/me", otelhttp.NewHandler(app.requiredAuthenticatedUser(app.showCurrentUserHandler), "showCurrentUser"))
+
+	router.Handler(http.MethodGet, "/v1/users/me/lists", otelhttp.NewHandler(app.requireActivatedUser(app.listMyListsHandler), "listMyLists"))
+	router.Handler(http.MethodPost, "/v1/users/me/lists", otelhttp.NewHandler(app.requireActivatedUser(app.createListHandler), "createList"))
+	router.Handler(http.MethodGet, "/v1/users/me/lists/:id", otelhttp.NewHandler(app.requireActivatedUser(app.showMyListHandler), "showMyList"))
+	router.Handler(http.MethodPatch, "/v1/users/me/lists/:id", otelhttp.NewHandler(app.requireActivatedUser(app.updateListHandler), "updateList"))
+	router.Handler(http.MethodDelete, "/v1/users/me/lists/:id", otelhttp.NewHandler(app.requireActivatedUser(app.deleteListHandler), "deleteList"))
+	router.Handler(http.MethodPost, "/v1/users/me/lists/:id/share", otelhttp.NewHandler(app.requireActivatedUser(app.shareListHandler), "shareList"))
+	router.Handler(http.MethodDelete, "/v1/users/me/lists/:id/share", otelhttp.NewHandler(app.requireActivatedUser(app.unshareListHandler), "unshareList"))
+	router.Handler(http.MethodPost, "/v1/users/me/lists/:id/entries", otelhttp.NewHandler(app.requireActivatedUser(app.addListEntryHandler), "addListEntry"))
+	router.Handler(http.MethodPatch, "/v1/users/me/lists/:id/entries/:movie_id", otelhttp.NewHandler(app.requireActivatedUser(app.updateListEntryHandler), "updateListEntry"))
+	router.Handler(http.MethodDelete, "/v1/users/me/lists/:id/entries/:movie_id", otelhttp.NewHandler(app.requireActivatedUser(app.removeListEntryHandler), "removeListEntry"))
+
+	router.Handler(http.MethodGet, "/v1/lists/:id", otelhttp.NewHandler(http.HandlerFunc(app.showPublicListHandler), "showPublicList"))
+	router.Handler(http.MethodGet, "/v1/shared/lists/:token", otelhttp.NewHandler(http.HandlerFunc(app.showSharedListHandler), "showSharedList"))
+
+	router.Handler(http.MethodGet, "/v1/images/*key", otelhttp.NewHandler(http.HandlerFunc(app.serveImageHandler), "serveImage"))
 
 	router.Handler(http.MethodPost, "/v1/tokens/authentication", otelhttp.NewHandler(http.HandlerFunc(app.createAuthenticationHandler), "createAuthentication"))
 
 	router.Handler(http.MethodGet, "/debug/vars", otelhttp.NewHandler(expvar.Handler(), "expvar"))
 
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	// httprouter won't register a static segment in the same position as a
+	// named parameter (e.g. /v1/movies/export next to /v1/movies/:id), so
+	// those routes live on a router of their own that falls through to the
+	// main one for everything else.
+	static := httprouter.New()
+
+	static.NotFound = router
+	static.MethodNotAllowed = router.MethodNotAllowed
+
+	static.Handler(http.MethodGet, "/v1/movies/export", otelhttp.NewHandler(app.requirePermission("movies:read", app.exportMoviesHandler), "exportMovies"))
+	static.Handler(http.MethodGet, "/v1/movies/lookup", otelhttp.NewHandler(app.requirePermission("movies:read", app.lookupMovieHandler), "lookupMovie"))
+	static.Handler(http.MethodGet, "/v1/movies/trash", otelhttp.NewHandler(app.requirePermission("movies:write", app.listTrashedMoviesHandler), "listTrashedMovies"))
+	static.Handler(http.MethodDelete, "/v1/movies/trash/:id", otelhttp.NewHandler(app.requirePermission("movies:purge", app.purgeMovieHandler), "purgeMovie"))
+
+	dispatch = app.authenticate(static)
+
+	return app.metrics(app.compress(app.recoverPanic(app.enableCORS(app.rateLimit(app.varyForwarded(dispatch))))))
 }
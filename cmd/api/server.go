@@ -23,6 +23,7 @@ func (app *application) serve() error {
 	}
 
 	shutdownError := make(chan error)
+	stopJobs := make(chan struct{})
 
 	go func() {
 		quit := make(chan os.Signal, 1)
@@ -38,11 +39,15 @@ func (app *application) serve() error {
 			shutdownError <- err
 		}
 
+		close(stopJobs)
+
 		app.logger.Info().Str("addr", srv.Addr).Msg("completing background tasks")
 		app.wg.Wait()
 		shutdownError <- nil
 	}()
 
+	app.startJobs(stopJobs)
+
 	app.logger.Info().Str("addr", srv.Addr).Str("env", app.config.env).Msg("starting server")
 	err := srv.ListenAndServe()
 	if !errors.Is(err, http.ErrServerClosed) {
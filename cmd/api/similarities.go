@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"greenlight.swsd2544.net/internal/data"
+	"greenlight.swsd2544.net/internal/errcode"
+	"greenlight.swsd2544.net/internal/validator"
+)
+
+var (
+	codeSimilarLimitTooSmall = errcode.New("similar.limit.too_small", "must be greater than zero")
+	codeSimilarLimitTooLarge = errcode.New("similar.limit.too_large", "must not be greater than the number of stored similar movies")
+)
+
+// similarMoviesHandler serves the movies most similar to a movie as of the
+// last background recompute, best first.
+func (app *application) similarMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	locale := app.readLocale(r, v)
+	limit := app.readInt(r.URL.Query(), "limit", 10, v)
+
+	v.Check(limit > 0, "limit", codeSimilarLimitTooSmall)
+	v.Check(limit <= app.config.similar.limit, "limit", codeSimilarLimitTooLarge)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	_, err = app.modelsFor(r).Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	similar, err := app.modelsFor(r).Similarities.GetForMovie(id, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Language")
+
+	movies := make([]*data.Movie, len(similar))
+	for i := range similar {
+		movies[i] = similar[i].Movie
+	}
+
+	app.linkMovies(r, movies...)
+
+	if locale != "" {
+		err = app.modelsFor(r).MovieTranslations.Localize(locale, movies...)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if localized(movies...) {
+			w.Header().Set("Content-Language", locale)
+		}
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"similar": similar}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
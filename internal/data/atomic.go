@@ -0,0 +1,167 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+)
+
+// Atomic runs fn with models whose every query is part of one transaction,
+// committed if fn returns nil and rolled back otherwise. The models run on a
+// single connection taken from the pool for the duration of fn, and the
+// transactions they begin themselves become savepoints of the outer one, so
+// operations that are atomic on their own stay atomic within it.
+func (m Models) Atomic(ctx context.Context, fn func(models Models) error) error {
+	conn, err := m.Movies.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	return conn.Raw(func(driverConn any) (err error) {
+		dc, ok := driverConn.(driver.Conn)
+		if !ok {
+			return fmt.Errorf("unsupported driver connection %T", driverConn)
+		}
+
+		ac := &atomicConn{Conn: dc}
+
+		err = ac.exec(ctx, "BEGIN")
+		if err != nil {
+			return err
+		}
+
+		committed := false
+
+		// A connection left inside the transaction must not go back to the
+		// pool, so it is discarded if even the rollback fails.
+		defer func() {
+			if committed {
+				return
+			}
+
+			if errRollback := ac.exec(context.Background(), "ROLLBACK"); errRollback != nil {
+				err = driver.ErrBadConn
+			}
+		}()
+
+		db := sql.OpenDB(atomicConnector{conn: ac})
+		db.SetMaxOpenConns(1)
+
+		err = fn(NewModels(db))
+
+		_ = db.Close()
+
+		if err != nil {
+			return err
+		}
+
+		err = ac.exec(ctx, "COMMIT")
+		if err != nil {
+			return err
+		}
+
+		committed = true
+
+		return nil
+	})
+}
+
+// atomicConn is a driver connection already inside a transaction. Begin
+// creates a savepoint instead of a transaction, and Close leaves the
+// connection open for the pool it was borrowed from.
+type atomicConn struct {
+	driver.Conn
+	savepoints int
+}
+
+func (c *atomicConn) exec(ctx context.Context, query string) error {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return errors.New("driver connection does not support ExecContext")
+	}
+
+	_, err := execer.ExecContext(ctx, query, nil)
+	return err
+}
+
+func (c *atomicConn) Close() error {
+	return nil
+}
+
+func (c *atomicConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *atomicConn) BeginTx(ctx context.Context, _ driver.TxOptions) (driver.Tx, error) {
+	c.savepoints++
+	name := fmt.Sprintf("atomic_%d", c.savepoints)
+
+	err := c.exec(ctx, "SAVEPOINT "+name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &savepoint{conn: c, name: name}, nil
+}
+
+func (c *atomicConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+
+	return c.Conn.Prepare(query)
+}
+
+func (c *atomicConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if execer, ok := c.Conn.(driver.ExecerContext); ok {
+		return execer.ExecContext(ctx, query, args)
+	}
+
+	return nil, driver.ErrSkip
+}
+
+func (c *atomicConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if queryer, ok := c.Conn.(driver.QueryerContext); ok {
+		return queryer.QueryContext(ctx, query, args)
+	}
+
+	return nil, driver.ErrSkip
+}
+
+type savepoint struct {
+	conn *atomicConn
+	name string
+}
+
+func (s *savepoint) Commit() error {
+	return s.conn.exec(context.Background(), "RELEASE SAVEPOINT "+s.name)
+}
+
+func (s *savepoint) Rollback() error {
+	return s.conn.exec(context.Background(), "ROLLBACK TO SAVEPOINT "+s.name)
+}
+
+// atomicConnector hands out the one connection of an atomic run.
+type atomicConnector struct {
+	conn *atomicConn
+}
+
+func (c atomicConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.conn, nil
+}
+
+func (c atomicConnector) Driver() driver.Driver {
+	return atomicDriver{}
+}
+
+type atomicDriver struct{}
+
+func (atomicDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("atomic connections can't be opened by name")
+}
@@ -0,0 +1,21 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+)
+
+// insertAuditRecord records an administrative action taken by the user,
+// with details encoded as JSON, inside the transaction that performs it.
+func insertAuditRecord(ctx context.Context, tx *sql.Tx, userID int64, action string, details any) error {
+	js, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO audit_records (user_id, action, details) VALUES ($1, $2, $3)`
+
+	_, err = tx.ExecContext(ctx, query, userID, action, js)
+	return err
+}
@@ -0,0 +1,492 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.swsd2544.net/internal/errcode"
+	"greenlight.swsd2544.net/internal/validator"
+)
+
+var (
+	ErrDuplicateSlug            = errors.New("duplicate slug")
+	ErrDuplicateCollectionEntry = errors.New("duplicate collection entry")
+)
+
+var SlugRX = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
+type Collection struct {
+	CreatedAt   time.Time `json:"created_at"`
+	CuratorID   *int64    `json:"curator_id,omitempty"`
+	Slug        string    `json:"slug"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Curator     string    `json:"curator,omitempty"`
+	ID          int64     `json:"id"`
+	MovieCount  int32     `json:"movie_count"`
+	Version     int32     `json:"version"`
+}
+
+type CollectionEntry struct {
+	AddedAt      time.Time `json:"added_at"`
+	Title        string    `json:"title"`
+	CollectionID int64     `json:"-"`
+	MovieID      int64     `json:"movie_id"`
+	Year         int32     `json:"year"`
+	Position     int32     `json:"position"`
+}
+
+// MovieCollection is a collection as embedded in a movie's representation.
+type MovieCollection struct {
+	Slug     string `json:"slug"`
+	Title    string `json:"title"`
+	Position int32  `json:"position"`
+}
+
+var (
+	codeCollectionSlugRequired          = errcode.New("collection.slug.required", "must be provided")
+	codeCollectionSlugTooLong           = errcode.New("collection.slug.too_long", "must not be more than 100 bytes long")
+	codeCollectionSlugInvalid           = errcode.New("collection.slug.invalid", "must only contain lower case letters, digits and single hyphens")
+	codeCollectionTitleRequired         = errcode.New("collection.title.required", "must be provided")
+	codeCollectionTitleTooLong          = errcode.New("collection.title.too_long", "must not be more than 200 bytes long")
+	codeCollectionDescriptionTooLong    = errcode.New("collection.description.too_long", "must not be more than 5000 bytes long")
+	codeCollectionEntryMovieIDRequired  = errcode.New("collection.movie_id.required", "must be provided")
+	codeCollectionEntryPositionNegative = errcode.New("collection.position.negative", "must not be negative")
+)
+
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+	v.Check(collection.Slug != "", "slug", codeCollectionSlugRequired)
+	v.Check(len(collection.Slug) <= 100, "slug", codeCollectionSlugTooLong)
+	v.Check(validator.Matches(collection.Slug, SlugRX), "slug", codeCollectionSlugInvalid)
+
+	v.Check(collection.Title != "", "title", codeCollectionTitleRequired)
+	v.Check(len(collection.Title) <= 200, "title", codeCollectionTitleTooLong)
+
+	v.Check(len(collection.Description) <= 5000, "description", codeCollectionDescriptionTooLong)
+}
+
+func ValidateCollectionEntry(v *validator.Validator, entry *CollectionEntry) {
+	v.Check(entry.MovieID > 0, "movie_id", codeCollectionEntryMovieIDRequired)
+	v.Check(entry.Position >= 0, "position", codeCollectionEntryPositionNegative)
+}
+
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+func (m CollectionModel) Insert(collection *Collection) error {
+	query := `INSERT INTO collections (slug, title, description, curator_id)
+	VALUES ($1, $2, $3, $4) RETURNING id, created_at, version`
+
+	args := []any{collection.Slug, collection.Title, collection.Description, collection.CuratorID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.ID, &collection.CreatedAt, &collection.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "collections_slug_key"`:
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+const collectionColumns = `collections.id, collections.created_at, collections.slug, collections.title,
+	collections.description, collections.curator_id, COALESCE(users.name, ''), collections.version,
+	(SELECT count(*) FROM collection_movies INNER JOIN movies ON movies.id = collection_movies.movie_id
+		WHERE collection_movies.collection_id = collections.id AND movies.deleted_at IS NULL)`
+
+const collectionTables = `collections LEFT JOIN users ON users.id = collections.curator_id`
+
+func scanCollection(row interface{ Scan(...any) error }, collection *Collection, extra ...any) error {
+	return row.Scan(append(extra,
+		&collection.ID,
+		&collection.CreatedAt,
+		&collection.Slug,
+		&collection.Title,
+		&collection.Description,
+		&collection.CuratorID,
+		&collection.Curator,
+		&collection.Version,
+		&collection.MovieCount,
+	)...)
+}
+
+func (m CollectionModel) GetBySlug(slug string) (*Collection, error) {
+	var collection Collection
+
+	query := `SELECT ` + collectionColumns + ` FROM ` + collectionTables + ` WHERE collections.slug = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanCollection(m.DB.QueryRowContext(ctx, query, slug), &collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &collection, nil
+}
+
+func (m CollectionModel) GetAll(title string, filters Filters) ([]*Collection, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), %s FROM %s
+	WHERE (to_tsvector('simple', collections.title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+	ORDER BY collections.%s %s, collections.id ASC LIMIT $2 OFFSET $3`,
+		collectionColumns, collectionTables, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, title, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	totalRecords := 0
+	collections := []*Collection{}
+
+	for rows.Next() {
+		var collection Collection
+
+		errScan := scanCollection(rows, &collection, &totalRecords)
+		if errScan != nil {
+			return nil, Metadata{}, errScan
+		}
+
+		collections = append(collections, &collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return collections, metadata, nil
+}
+
+func (m CollectionModel) Update(collection *Collection) error {
+	query := `UPDATE collections SET slug = $1, title = $2, description = $3, version = version + 1
+	WHERE id = $4 AND version = $5 RETURNING version`
+
+	args := []any{collection.Slug, collection.Title, collection.Description, collection.ID, collection.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "collections_slug_key"`:
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m CollectionModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM collections WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetEntries lists the movies of a collection in order, skipping movies in
+// the trash.
+func (m CollectionModel) GetEntries(collectionID int64, filters Filters) ([]*CollectionEntry, Metadata, error) {
+	query := `SELECT count(*) OVER(), collection_movies.collection_id, collection_movies.movie_id,
+	movies.title, movies.year, collection_movies.position, collection_movies.added_at
+	FROM collection_movies INNER JOIN movies ON movies.id = collection_movies.movie_id
+	WHERE collection_movies.collection_id = $1 AND movies.deleted_at IS NULL
+	ORDER BY collection_movies.position ASC LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, collectionID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	totalRecords := 0
+	entries := []*CollectionEntry{}
+
+	for rows.Next() {
+		var entry CollectionEntry
+
+		errScan := rows.Scan(
+			&totalRecords,
+			&entry.CollectionID,
+			&entry.MovieID,
+			&entry.Title,
+			&entry.Year,
+			&entry.Position,
+			&entry.AddedAt,
+		)
+		if errScan != nil {
+			return nil, Metadata{}, errScan
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
+
+// AddEntry inserts a movie into a collection at entry.Position, shifting the
+// movies after it down. A zero or out-of-range position appends the movie.
+func (m CollectionModel) AddEntry(entry *CollectionEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Lock the collection so concurrent inserts can't claim the same slot.
+	_, err = tx.ExecContext(ctx, `SELECT id FROM collections WHERE id = $1 FOR UPDATE`, entry.CollectionID)
+	if err != nil {
+		return err
+	}
+
+	var last int32
+
+	query := `SELECT COALESCE(max(position), 0) FROM collection_movies WHERE collection_id = $1`
+
+	err = tx.QueryRowContext(ctx, query, entry.CollectionID).Scan(&last)
+	if err != nil {
+		return err
+	}
+
+	if entry.Position < 1 || entry.Position > last {
+		entry.Position = last + 1
+	}
+
+	query = `UPDATE collection_movies SET position = position + 1 WHERE collection_id = $1 AND position >= $2`
+
+	_, err = tx.ExecContext(ctx, query, entry.CollectionID, entry.Position)
+	if err != nil {
+		return err
+	}
+
+	query = `INSERT INTO collection_movies (collection_id, movie_id, position) VALUES ($1, $2, $3) RETURNING added_at`
+
+	err = tx.QueryRowContext(ctx, query, entry.CollectionID, entry.MovieID, entry.Position).Scan(&entry.AddedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "collection_movies_pkey"`:
+			return ErrDuplicateCollectionEntry
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// MoveEntry moves a movie to entry.Position within its collection, shifting
+// the movies in between. Positions past the end are clamped to the last slot.
+func (m CollectionModel) MoveEntry(entry *CollectionEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var current, last int32
+
+	query := `SELECT position, (SELECT max(position) FROM collection_movies WHERE collection_id = $1)
+	FROM collection_movies WHERE collection_id = $1 AND movie_id = $2 FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, entry.CollectionID, entry.MovieID).Scan(&current, &last)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if entry.Position < 1 || entry.Position > last {
+		entry.Position = last
+	}
+
+	switch {
+	case entry.Position > current:
+		query = `UPDATE collection_movies SET position = position - 1
+		WHERE collection_id = $1 AND position > $2 AND position <= $3`
+		_, err = tx.ExecContext(ctx, query, entry.CollectionID, current, entry.Position)
+	case entry.Position < current:
+		query = `UPDATE collection_movies SET position = position + 1
+		WHERE collection_id = $1 AND position >= $3 AND position < $2`
+		_, err = tx.ExecContext(ctx, query, entry.CollectionID, current, entry.Position)
+	}
+	if err != nil {
+		return err
+	}
+
+	query = `UPDATE collection_movies SET position = $1 WHERE collection_id = $2 AND movie_id = $3
+	RETURNING added_at`
+
+	err = tx.QueryRowContext(ctx, query, entry.Position, entry.CollectionID, entry.MovieID).Scan(&entry.AddedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveEntry deletes a movie from a collection and closes the gap it leaves
+// in the ordering.
+func (m CollectionModel) RemoveEntry(collectionID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var position int32
+
+	query := `DELETE FROM collection_movies WHERE collection_id = $1 AND movie_id = $2 RETURNING position`
+
+	err = tx.QueryRowContext(ctx, query, collectionID, movieID).Scan(&position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	query = `UPDATE collection_movies SET position = position - 1 WHERE collection_id = $1 AND position > $2`
+
+	_, err = tx.ExecContext(ctx, query, collectionID, position)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// EmbedInMovies sets Collections on each of the movies to the collections it
+// belongs to, ordered by title.
+func (m CollectionModel) EmbedInMovies(movies ...*Movie) error {
+	if len(movies) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*Movie, len(movies))
+	ids := make([]int64, 0, len(movies))
+
+	for _, movie := range movies {
+		movie.Collections = []*MovieCollection{}
+		byID[movie.ID] = movie
+		ids = append(ids, movie.ID)
+	}
+
+	query := `SELECT collection_movies.movie_id, collections.slug, collections.title, collection_movies.position
+	FROM collection_movies INNER JOIN collections ON collections.id = collection_movies.collection_id
+	WHERE collection_movies.movie_id = ANY($1)
+	ORDER BY collections.title, collections.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var movieID int64
+		var collection MovieCollection
+
+		errScan := rows.Scan(&movieID, &collection.Slug, &collection.Title, &collection.Position)
+		if errScan != nil {
+			return errScan
+		}
+
+		if movie, ok := byID[movieID]; ok {
+			movie.Collections = append(movie.Collections, &collection)
+		}
+	}
+
+	return rows.Err()
+}
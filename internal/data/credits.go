@@ -0,0 +1,336 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.swsd2544.net/internal/errcode"
+	"greenlight.swsd2544.net/internal/validator"
+)
+
+const (
+	RoleDirector = "director"
+	RoleWriter   = "writer"
+	RoleActor    = "actor"
+)
+
+var ErrDuplicateCredit = errors.New("duplicate credit")
+
+// Credit links a person to a movie in a given role. PersonName is filled in
+// when credits are read back for display.
+type Credit struct {
+	Character    string `json:"character,omitempty"`
+	Role         string `json:"role"`
+	PersonName   string `json:"person_name,omitempty"`
+	ID           int64  `json:"id"`
+	MovieID      int64  `json:"movie_id"`
+	PersonID     int64  `json:"person_id"`
+	BillingOrder int32  `json:"billing_order"`
+}
+
+// FilmographyEntry is one credit of a person, described from the movie's
+// side.
+type FilmographyEntry struct {
+	Title        string `json:"title"`
+	Role         string `json:"role"`
+	Character    string `json:"character,omitempty"`
+	MovieID      int64  `json:"movie_id"`
+	Year         int32  `json:"year"`
+	BillingOrder int32  `json:"billing_order"`
+}
+
+var (
+	codeCreditPersonIDRequired     = errcode.New("credit.person_id.required", "must be provided")
+	codeCreditRoleInvalid          = errcode.New("credit.role.invalid", "must be one of director, writer or actor")
+	codeCreditCharacterTooLong     = errcode.New("credit.character.too_long", "must not be more than 500 bytes long")
+	codeCreditCharacterNotActor    = errcode.New("credit.character.not_actor", "must only be set for actors")
+	codeCreditBillingOrderNegative = errcode.New("credit.billing_order.negative", "must not be negative")
+)
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID > 0, "person_id", codeCreditPersonIDRequired)
+	v.Check(validator.PermittedValue(credit.Role, RoleDirector, RoleWriter, RoleActor), "role", codeCreditRoleInvalid)
+	v.Check(len(credit.Character) <= 500, "character", codeCreditCharacterTooLong)
+	v.Check(credit.Role == RoleActor || credit.Character == "", "character", codeCreditCharacterNotActor)
+	v.Check(credit.BillingOrder >= 0, "billing_order", codeCreditBillingOrderNegative)
+}
+
+type CreditModel struct {
+	DB *sql.DB
+}
+
+func (m CreditModel) Insert(credit *Credit) error {
+	query := `INSERT INTO movie_credits (movie_id, person_id, role, character, billing_order)
+	VALUES ($1, $2, $3, $4, $5) RETURNING id`
+
+	args := []any{credit.MovieID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "movie_credits_unique"`:
+			return ErrDuplicateCredit
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m CreditModel) Get(movieID, id int64) (*Credit, error) {
+	if movieID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	var credit Credit
+
+	query := `SELECT movie_credits.id, movie_credits.movie_id, movie_credits.person_id, people.name,
+	movie_credits.role, movie_credits.character, movie_credits.billing_order
+	FROM movie_credits INNER JOIN people ON people.id = movie_credits.person_id
+	WHERE movie_credits.movie_id = $1 AND movie_credits.id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, id).Scan(
+		&credit.ID,
+		&credit.MovieID,
+		&credit.PersonID,
+		&credit.PersonName,
+		&credit.Role,
+		&credit.Character,
+		&credit.BillingOrder,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &credit, nil
+}
+
+func (m CreditModel) Update(credit *Credit) error {
+	query := `UPDATE movie_credits SET role = $1, character = $2, billing_order = $3
+	WHERE movie_id = $4 AND id = $5`
+
+	args := []any{credit.Role, credit.Character, credit.BillingOrder, credit.MovieID, credit.ID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "movie_credits_unique"`:
+			return ErrDuplicateCredit
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m CreditModel) Delete(movieID, id int64) error {
+	if movieID < 1 || id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM movie_credits WHERE movie_id = $1 AND id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAllForMovie returns the movie's credits with directors first, then
+// writers, then the cast in billing order.
+func (m CreditModel) GetAllForMovie(movieID int64) ([]*Credit, error) {
+	query := `SELECT movie_credits.id, movie_credits.movie_id, movie_credits.person_id, people.name,
+	movie_credits.role, movie_credits.character, movie_credits.billing_order
+	FROM movie_credits INNER JOIN people ON people.id = movie_credits.person_id
+	WHERE movie_credits.movie_id = $1
+	ORDER BY array_position(ARRAY['director', 'writer', 'actor'], movie_credits.role),
+	movie_credits.billing_order, movie_credits.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+		var credit Credit
+
+		errScan := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.PersonName,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+		)
+		if errScan != nil {
+			return nil, errScan
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// EmbedInMovies sets Credits on each of the movies, ordered as GetAllForMovie
+// orders them.
+func (m CreditModel) EmbedInMovies(movies ...*Movie) error {
+	if len(movies) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*Movie, len(movies))
+	ids := make([]int64, 0, len(movies))
+
+	for _, movie := range movies {
+		movie.Credits = []*Credit{}
+		byID[movie.ID] = movie
+		ids = append(ids, movie.ID)
+	}
+
+	query := `SELECT movie_credits.id, movie_credits.movie_id, movie_credits.person_id, people.name,
+	movie_credits.role, movie_credits.character, movie_credits.billing_order
+	FROM movie_credits INNER JOIN people ON people.id = movie_credits.person_id
+	WHERE movie_credits.movie_id = ANY($1)
+	ORDER BY array_position(ARRAY['director', 'writer', 'actor'], movie_credits.role),
+	movie_credits.billing_order, movie_credits.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var credit Credit
+
+		errScan := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.PersonName,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+		)
+		if errScan != nil {
+			return errScan
+		}
+
+		if movie, ok := byID[credit.MovieID]; ok {
+			movie.Credits = append(movie.Credits, &credit)
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetFilmography returns every credit of a person on a movie that is not in
+// the trash, newest movie first.
+func (m CreditModel) GetFilmography(personID int64) ([]*FilmographyEntry, error) {
+	query := `SELECT movies.id, movies.title, movies.year, movie_credits.role,
+	movie_credits.character, movie_credits.billing_order
+	FROM movie_credits INNER JOIN movies ON movies.id = movie_credits.movie_id
+	WHERE movie_credits.person_id = $1 AND movies.deleted_at IS NULL
+	ORDER BY movies.year DESC, movies.id, movie_credits.role`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, personID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	entries := []*FilmographyEntry{}
+
+	for rows.Next() {
+		var entry FilmographyEntry
+
+		errScan := rows.Scan(
+			&entry.MovieID,
+			&entry.Title,
+			&entry.Year,
+			&entry.Role,
+			&entry.Character,
+			&entry.BillingOrder,
+		)
+		if errScan != nil {
+			return nil, errScan
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
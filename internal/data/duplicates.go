@@ -0,0 +1,366 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// ErrDuplicateMovie is returned by Insert when a movie with the same
+// normalized title and year is already outside the trash.
+var ErrDuplicateMovie = errors.New("duplicate movie")
+
+// NormalizeTitle reduces a title to the key duplicates are detected by: lower
+// case, with punctuation and runs of whitespace collapsed into single spaces.
+// Letters, digits and combining marks are kept in every script, which SQL's
+// locale-dependent character classes can't match, so movies.title_key is only
+// ever computed by this function; see BackfillTitleKeys.
+func NormalizeTitle(title string) string {
+	fields := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r)
+	})
+
+	return strings.Join(fields, " ")
+}
+
+// MovieMerge describes what Merge moved from the duplicate movie to the
+// canonical one. It is also the audit record's details. PosterKey is the key
+// of the duplicate's poster when the canonical movie didn't take it over, for
+// the caller to remove from storage.
+type MovieMerge struct {
+	PosterKey    string `json:"-"`
+	SourceID     int64  `json:"source_id"`
+	TargetID     int64  `json:"target_id"`
+	Reviews      int64  `json:"reviews"`
+	ListEntries  int64  `json:"list_entries"`
+	Collections  int64  `json:"collections"`
+	Credits      int64  `json:"credits"`
+	Translations int64  `json:"translations"`
+	ExternalIDs  int64  `json:"external_ids"`
+}
+
+// FindDuplicates returns the movies outside the trash whose normalized title
+// and year match the given movie's.
+func (m MovieModel) FindDuplicates(movie *Movie) ([]*Movie, error) {
+	query := `SELECT id, created_at, title, year, runtime, genres, rating, votes, version, poster_key, poster_url, external_ids
+	FROM movies WHERE title_key = $1 AND year = $2 AND id <> $3 AND deleted_at IS NULL
+	ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, NormalizeTitle(movie.Title), movie.Year, movie.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var duplicate Movie
+
+		errScan := rows.Scan(
+			&duplicate.ID,
+			&duplicate.CreatedAt,
+			&duplicate.Title,
+			&duplicate.Year,
+			&duplicate.Runtime,
+			pq.Array(&duplicate.Genres),
+			&duplicate.Rating,
+			&duplicate.Votes,
+			&duplicate.Version,
+			&duplicate.PosterKey,
+			&duplicate.PosterURL,
+			&duplicate.ExternalIDs,
+		)
+		if errScan != nil {
+			return nil, errScan
+		}
+
+		movies = append(movies, &duplicate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// lockTitleKey serializes the transactions inserting a movie with the given
+// title and year until they end, so that only one of two concurrent inserts
+// of the same film can pass the duplicate check.
+func lockTitleKey(ctx context.Context, tx *sql.Tx, movie *Movie) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1 || ' ' || $2::text))`, NormalizeTitle(movie.Title), movie.Year)
+	return err
+}
+
+// hasDuplicate reports whether a movie outside the trash has the normalized
+// title and year of the given one.
+func hasDuplicate(ctx context.Context, tx *sql.Tx, movie *Movie) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM movies WHERE title_key = $1 AND year = $2 AND id <> $3 AND deleted_at IS NULL)`
+
+	var exists bool
+
+	err := tx.QueryRowContext(ctx, query, NormalizeTitle(movie.Title), movie.Year, movie.ID).Scan(&exists)
+	return exists, err
+}
+
+// BackfillTitleKeys computes the title key of the movies that don't have one
+// yet, which are those created before movies.title_key was added, and returns
+// how many it filled in.
+func (m MovieModel) BackfillTitleKeys(ctx context.Context) (int64, error) {
+	rows, err := m.DB.QueryContext(ctx, `SELECT id, title FROM movies WHERE title_key IS NULL`)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	titles := make(map[int64]string)
+
+	for rows.Next() {
+		var id int64
+		var title string
+
+		errScan := rows.Scan(&id, &title)
+		if errScan != nil {
+			return 0, errScan
+		}
+
+		titles[id] = title
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var filled int64
+
+	for id, title := range titles {
+		result, err := m.DB.ExecContext(ctx, `UPDATE movies SET title_key = $1 WHERE id = $2 AND title_key IS NULL`, NormalizeTitle(title), id)
+		if err != nil {
+			return filled, err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return filled, err
+		}
+
+		filled += rowsAffected
+	}
+
+	return filled, nil
+}
+
+// GetRedirect returns the ID of the movie that a merged-away movie ID now
+// refers to.
+func (m MovieModel) GetRedirect(oldID int64) (int64, error) {
+	var movieID int64
+
+	query := `SELECT movie_id FROM movie_redirects WHERE old_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, oldID).Scan(&movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return movieID, nil
+}
+
+// Merge folds the duplicate movie sourceID into the canonical movie targetID
+// in a single transaction. Reviews, list and collection entries, credits and
+// translations move to the target unless it already has an equivalent (a
+// review by the same user, the same list, ...), in which case the target's
+// is kept. The target adopts the source's poster if it has none, and its
+// external IDs for the providers it has none for, which makes a new revision
+// of the target. The source is then deleted, its ID redirects to the target,
+// and an audit record is written for userID.
+func (m MovieModel) Merge(sourceID, targetID, userID int64) (*MovieMerge, error) {
+	if sourceID < 1 || targetID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var locked int
+
+	query := `SELECT count(*) FROM (SELECT id FROM movies WHERE id IN ($1, $2) AND deleted_at IS NULL FOR UPDATE) m`
+
+	err = tx.QueryRowContext(ctx, query, sourceID, targetID).Scan(&locked)
+	if err != nil {
+		return nil, err
+	}
+
+	if locked != 2 {
+		return nil, ErrRecordNotFound
+	}
+
+	// The source's list entries are renumbered or moved below, so hold off
+	// anyone else changing those lists until then.
+	_, err = lockListsOf(ctx, tx, `id = $1`, sourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	merge := &MovieMerge{SourceID: sourceID, TargetID: targetID}
+
+	moves := []struct {
+		count *int64
+		query string
+	}{
+		{&merge.Reviews, `UPDATE reviews SET movie_id = $2 WHERE movie_id = $1
+		AND NOT EXISTS (SELECT 1 FROM reviews existing WHERE existing.movie_id = $2 AND existing.user_id = reviews.user_id)`},
+		{&merge.Credits, `UPDATE movie_credits SET movie_id = $2 WHERE movie_id = $1
+		AND NOT EXISTS (SELECT 1 FROM movie_credits existing WHERE existing.movie_id = $2
+			AND existing.person_id = movie_credits.person_id AND existing.role = movie_credits.role
+			AND existing.character = movie_credits.character)`},
+		{&merge.Translations, `UPDATE movie_translations SET movie_id = $2 WHERE movie_id = $1
+		AND NOT EXISTS (SELECT 1 FROM movie_translations existing WHERE existing.movie_id = $2
+			AND existing.locale = movie_translations.locale)`},
+		// Lists and collections holding both movies lose the source's entry,
+		// so close the gap it leaves first.
+		{nil, `UPDATE list_entries SET position = list_entries.position - 1
+		FROM list_entries source
+		WHERE source.movie_id = $1 AND list_entries.list_id = source.list_id AND list_entries.position > source.position
+		AND EXISTS (SELECT 1 FROM list_entries existing WHERE existing.list_id = source.list_id AND existing.movie_id = $2)`},
+		{&merge.ListEntries, `UPDATE list_entries SET movie_id = $2 WHERE movie_id = $1
+		AND NOT EXISTS (SELECT 1 FROM list_entries existing WHERE existing.list_id = list_entries.list_id AND existing.movie_id = $2)`},
+		{nil, `UPDATE collection_movies SET position = collection_movies.position - 1
+		FROM collection_movies source
+		WHERE source.movie_id = $1 AND collection_movies.collection_id = source.collection_id
+		AND collection_movies.position > source.position
+		AND EXISTS (SELECT 1 FROM collection_movies existing WHERE existing.collection_id = source.collection_id AND existing.movie_id = $2)`},
+		{&merge.Collections, `UPDATE collection_movies SET movie_id = $2 WHERE movie_id = $1
+		AND NOT EXISTS (SELECT 1 FROM collection_movies existing WHERE existing.collection_id = collection_movies.collection_id AND existing.movie_id = $2)`},
+		{nil, `UPDATE movies SET poster_key = source.poster_key, poster_url = source.poster_url
+		FROM movies source WHERE source.id = $1 AND movies.id = $2 AND movies.poster_key = ''`},
+		{nil, `UPDATE movie_redirects SET movie_id = $2 WHERE movie_id = $1`},
+	}
+
+	for _, move := range moves {
+		result, errExec := tx.ExecContext(ctx, move.query, sourceID, targetID)
+		if errExec != nil {
+			return nil, errExec
+		}
+
+		if move.count != nil {
+			*move.count, err = result.RowsAffected()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = mergeExternalIDs(ctx, tx, merge)
+	if err != nil {
+		return nil, err
+	}
+
+	err = updateMovieRating(ctx, tx, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The target only takes over the source's poster if it had none of its
+	// own; otherwise the source's poster is left to the caller to remove.
+	query = `DELETE FROM movies WHERE id = $1 RETURNING CASE
+		WHEN poster_key = (SELECT poster_key FROM movies WHERE id = $2) THEN '' ELSE poster_key END`
+
+	err = tx.QueryRowContext(ctx, query, sourceID, targetID).Scan(&merge.PosterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO movie_redirects (old_id, movie_id) VALUES ($1, $2)`, sourceID, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertAuditRecord(ctx, tx, userID, "movies.merge", merge)
+	if err != nil {
+		return nil, err
+	}
+
+	return merge, tx.Commit()
+}
+
+// mergeExternalIDs moves the source's external IDs to the target for the
+// providers the target has none for. The source gives them up first, as an ID
+// may only be held by one movie.
+func mergeExternalIDs(ctx context.Context, tx *sql.Tx, merge *MovieMerge) error {
+	var sourceIDs, targetIDs ExternalIDs
+
+	query := `SELECT external_ids FROM movies WHERE id = $1`
+
+	err := tx.QueryRowContext(ctx, query, merge.SourceID).Scan(&sourceIDs)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, query, merge.TargetID).Scan(&targetIDs)
+	if err != nil {
+		return err
+	}
+
+	for provider, id := range sourceIDs {
+		if _, ok := targetIDs[provider]; ok {
+			continue
+		}
+
+		if targetIDs == nil {
+			targetIDs = make(ExternalIDs)
+		}
+
+		targetIDs[provider] = id
+		merge.ExternalIDs++
+	}
+
+	if merge.ExternalIDs == 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE movies SET external_ids = '{}' WHERE id = $1`, merge.SourceID)
+	if err != nil {
+		return err
+	}
+
+	query = `UPDATE movies SET external_ids = $2, version = version + 1 WHERE id = $1`
+
+	_, err = tx.ExecContext(ctx, query, merge.TargetID, targetIDs)
+	if err != nil {
+		return err
+	}
+
+	return recordRevision(ctx, tx, merge.TargetID)
+}
@@ -0,0 +1,143 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.swsd2544.net/internal/errcode"
+	"greenlight.swsd2544.net/internal/validator"
+)
+
+var ErrDuplicateExternalID = errors.New("duplicate external id")
+
+// ExternalProviders maps every provider a movie can carry an identifier for
+// to the format that identifier must have.
+var ExternalProviders = map[string]*regexp.Regexp{
+	"imdb":     regexp.MustCompile(`^tt\d{7,8}$`),
+	"tmdb":     regexp.MustCompile(`^[1-9]\d*$`),
+	"wikidata": regexp.MustCompile(`^Q[1-9]\d*$`),
+}
+
+// SupportedExternalProviders returns the keys of ExternalProviders in a
+// stable order.
+func SupportedExternalProviders() []string {
+	providers := make([]string, 0, len(ExternalProviders))
+	for provider := range ExternalProviders {
+		providers = append(providers, provider)
+	}
+
+	sort.Strings(providers)
+
+	return providers
+}
+
+// ExternalIDs maps a provider name to the movie's identifier there, e.g.
+// {"imdb": "tt0111161"}. It is stored as a jsonb object.
+type ExternalIDs map[string]string
+
+func (ids *ExternalIDs) Scan(src any) error {
+	if src == nil {
+		*ids = nil
+		return nil
+	}
+
+	b, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into ExternalIDs", src)
+	}
+
+	return json.Unmarshal(b, ids)
+}
+
+func (ids ExternalIDs) Value() (driver.Value, error) {
+	if ids == nil {
+		return []byte("{}"), nil
+	}
+
+	return json.Marshal(ids)
+}
+
+var (
+	codeExternalIDProviderUnknown = errcode.New("external_id.provider.unknown", "contains unknown provider \"{provider}\", must be one of {providers}")
+	codeExternalIDInvalid         = errcode.New("external_id.invalid", "must be a valid {provider} ID")
+)
+
+func ValidateExternalID(v *validator.Validator, key, provider, id string) {
+	format, ok := ExternalProviders[provider]
+	if !ok {
+		v.AddError(key, codeExternalIDProviderUnknown, errcode.Params{"provider": provider, "providers": SupportedExternalProviders()})
+		return
+	}
+
+	v.Check(format.MatchString(id), key, codeExternalIDInvalid, errcode.Params{"provider": provider})
+}
+
+func ValidateExternalIDs(v *validator.Validator, ids ExternalIDs) {
+	providers := make([]string, 0, len(ids))
+	for provider := range ids {
+		providers = append(providers, provider)
+	}
+
+	sort.Strings(providers)
+
+	for _, provider := range providers {
+		ValidateExternalID(v, validator.Path("external_ids", provider), provider, ids[provider])
+	}
+}
+
+// isDuplicateExternalID reports whether err is a violation of one of the
+// per-provider unique indexes on movies.external_ids.
+func isDuplicateExternalID(err error) bool {
+	return strings.HasPrefix(err.Error(), `pq: duplicate key value violates unique constraint "movies_external_ids_`)
+}
+
+// GetByExternalID returns the movie outside the trash that carries the
+// provider's identifier.
+func (m MovieModel) GetByExternalID(provider, id string) (*Movie, error) {
+	if _, ok := ExternalProviders[provider]; !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	var movie Movie
+
+	query := `SELECT id, created_at, title, year, runtime,
+	genres, rating, votes, version, poster_key, poster_url, external_ids
+	FROM movies WHERE external_ids ->> $1 = $2 AND deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, provider, id).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Rating,
+		&movie.Votes,
+		&movie.Version,
+		&movie.PosterKey,
+		&movie.PosterURL,
+		&movie.ExternalIDs,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
@@ -4,6 +4,7 @@ import (
 	"math"
 	"strings"
 
+	"greenlight.swsd2544.net/internal/errcode"
 	"greenlight.swsd2544.net/internal/validator"
 )
 
@@ -14,13 +15,21 @@ type Filters struct {
 	PageSize     int
 }
 
+var (
+	codeFiltersPageTooSmall     = errcode.New("filters.page.too_small", "must be greater than zero")
+	codeFiltersPageTooLarge     = errcode.New("filters.page.too_large", "must be a maximum of 10 million")
+	codeFiltersPageSizeTooSmall = errcode.New("filters.page_size.too_small", "must be greater than zero")
+	codeFiltersPageSizeTooLarge = errcode.New("filters.page_size.too_large", "must be a maximum of 100")
+	codeFiltersSortInvalid      = errcode.New("filters.sort.invalid", "invalid sort value")
+)
+
 func ValidateFilters(v *validator.Validator, f Filters) {
-	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
-	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.PageSize <= 100, "page", "must be a maximum of 100")
+	v.Check(f.Page > 0, "page", codeFiltersPageTooSmall)
+	v.Check(f.Page <= 10_000_000, "page", codeFiltersPageTooLarge)
+	v.Check(f.PageSize > 0, "page_size", codeFiltersPageSizeTooSmall)
+	v.Check(f.PageSize <= 100, "page_size", codeFiltersPageSizeTooLarge)
 
-	v.Check(validator.PermittedValue(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+	v.Check(validator.PermittedValue(f.Sort, f.SortSafeList...), "sort", codeFiltersSortInvalid)
 }
 
 func (f Filters) sortColumn() string {
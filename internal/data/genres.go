@@ -0,0 +1,393 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.swsd2544.net/internal/errcode"
+	"greenlight.swsd2544.net/internal/validator"
+)
+
+var ErrDuplicateGenre = errors.New("duplicate genre")
+
+type Genre struct {
+	CreatedAt  time.Time `json:"-"`
+	Slug       string    `json:"slug"`
+	Name       string    `json:"name"`
+	Aliases    []string  `json:"aliases"`
+	MovieCount int64     `json:"movie_count"`
+}
+
+// GenreSlug normalizes a genre name or alias for lookup: lower case, with
+// every run of characters other than the ASCII letters and digits collapsed
+// into a single hyphen. The genres migration mirrors this in SQL, which is why
+// it sticks to ASCII rather than whatever the database's locale calls a letter.
+func GenreSlug(name string) string {
+	var b strings.Builder
+
+	separate := false
+
+	for _, r := range strings.ToLower(name) {
+		if ('a' <= r && r <= 'z') || ('0' <= r && r <= '9') {
+			if separate && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			separate = false
+			b.WriteRune(r)
+			continue
+		}
+
+		separate = true
+	}
+
+	return b.String()
+}
+
+// GenreCatalog maps the slug and every alias of each known genre to the
+// genre's canonical slug.
+type GenreCatalog map[string]string
+
+// Canonical returns the canonical slug for a genre name, slug or alias.
+func (c GenreCatalog) Canonical(name string) (string, bool) {
+	slug, ok := c[GenreSlug(name)]
+	return slug, ok
+}
+
+// CanonicalAll maps names to their canonical slugs, leaving unknown names
+// untouched.
+func (c GenreCatalog) CanonicalAll(names []string) []string {
+	slugs := make([]string, len(names))
+
+	for i, name := range names {
+		slug, ok := c.Canonical(name)
+		if !ok {
+			slug = name
+		}
+		slugs[i] = slug
+	}
+
+	return slugs
+}
+
+var (
+	codeGenreSlugRequired     = errcode.New("genre.slug.required", "must be provided")
+	codeGenreSlugInvalid      = errcode.New("genre.slug.invalid", "must only contain lower case letters, digits and single hyphens")
+	codeGenreNameRequired     = errcode.New("genre.name.required", "must be provided")
+	codeGenreNameTooLong      = errcode.New("genre.name.too_long", "must not be more than 100 bytes long")
+	codeGenreAliasesRequired  = errcode.New("genre.aliases.required", "must be provided")
+	codeGenreAliasesTooMany   = errcode.New("genre.aliases.too_many", "must not contain more than 20 aliases")
+	codeGenreAliasesEmpty     = errcode.New("genre.aliases.empty", "must not contain empty values")
+	codeGenreAliasesOwnSlug   = errcode.New("genre.aliases.own_slug", "must not contain the genre's own slug")
+	codeGenreAliasesDuplicate = errcode.New("genre.aliases.duplicate", "must not contain duplicate values")
+)
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(genre.Slug != "", "slug", codeGenreSlugRequired)
+	v.Check(genre.Slug == GenreSlug(genre.Slug), "slug", codeGenreSlugInvalid)
+
+	v.Check(genre.Name != "", "name", codeGenreNameRequired)
+	v.Check(len(genre.Name) <= 100, "name", codeGenreNameTooLong)
+
+	v.Check(genre.Aliases != nil, "aliases", codeGenreAliasesRequired)
+	v.Check(len(genre.Aliases) <= 20, "aliases", codeGenreAliasesTooMany)
+
+	for i, alias := range genre.Aliases {
+		genre.Aliases[i] = GenreSlug(alias)
+		v.Check(genre.Aliases[i] != "", validator.Path("aliases", i), codeGenreAliasesEmpty)
+		v.Check(genre.Aliases[i] != genre.Slug, validator.Path("aliases", i), codeGenreAliasesOwnSlug)
+	}
+
+	v.Check(validator.Unique(genre.Aliases), "aliases", codeGenreAliasesDuplicate)
+}
+
+type GenreModel struct {
+	DB *sql.DB
+}
+
+// Catalog loads the lookup table used to validate and normalize movie genres.
+func (m GenreModel) Catalog() (GenreCatalog, error) {
+	query := `SELECT slug, aliases FROM genres`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	catalog := make(GenreCatalog)
+
+	for rows.Next() {
+		var slug string
+		var aliases []string
+
+		errScan := rows.Scan(&slug, pq.Array(&aliases))
+		if errScan != nil {
+			return nil, errScan
+		}
+
+		catalog[slug] = slug
+		for _, alias := range aliases {
+			catalog[alias] = slug
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return catalog, nil
+}
+
+// GetAll lists every genre along with how many movies outside the trash use
+// it.
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `SELECT genres.slug, genres.created_at, genres.name, genres.aliases,
+	(SELECT count(*) FROM movies WHERE movies.genres @> ARRAY[genres.slug] AND movies.deleted_at IS NULL)
+	FROM genres ORDER BY genres.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		errScan := rows.Scan(
+			&genre.Slug,
+			&genre.CreatedAt,
+			&genre.Name,
+			pq.Array(&genre.Aliases),
+			&genre.MovieCount,
+		)
+		if errScan != nil {
+			return nil, errScan
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+func (m GenreModel) Get(slug string) (*Genre, error) {
+	var genre Genre
+
+	query := `SELECT genres.slug, genres.created_at, genres.name, genres.aliases,
+	(SELECT count(*) FROM movies WHERE movies.genres @> ARRAY[genres.slug] AND movies.deleted_at IS NULL)
+	FROM genres WHERE genres.slug = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, slug).Scan(
+		&genre.Slug,
+		&genre.CreatedAt,
+		&genre.Name,
+		pq.Array(&genre.Aliases),
+		&genre.MovieCount,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &genre, nil
+}
+
+// conflicts reports whether the genre's slug or aliases are already used by
+// another genre, either as a slug or an alias.
+func (m GenreModel) conflicts(ctx context.Context, tx *sql.Tx, genre *Genre) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM genres WHERE slug <> $1
+	AND (slug = ANY($2) OR aliases && $2 OR $1 = ANY(aliases)))`
+
+	var exists bool
+
+	err := tx.QueryRowContext(ctx, query, genre.Slug, pq.Array(genre.Aliases)).Scan(&exists)
+	return exists, err
+}
+
+func (m GenreModel) Insert(genre *Genre) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	conflict, err := m.conflicts(ctx, tx, genre)
+	if err != nil {
+		return err
+	}
+
+	if conflict {
+		return ErrDuplicateGenre
+	}
+
+	query := `INSERT INTO genres (slug, name, aliases) VALUES ($1, $2, $3) RETURNING created_at`
+
+	err = tx.QueryRowContext(ctx, query, genre.Slug, genre.Name, pq.Array(genre.Aliases)).Scan(&genre.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "genres_pkey"`:
+			return ErrDuplicateGenre
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Update saves the genre's display name and aliases. The slug is the genre's
+// identity and is never changed; use Merge to fold one genre into another.
+func (m GenreModel) Update(genre *Genre) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	conflict, err := m.conflicts(ctx, tx, genre)
+	if err != nil {
+		return err
+	}
+
+	if conflict {
+		return ErrDuplicateGenre
+	}
+
+	query := `UPDATE genres SET name = $1, aliases = $2 WHERE slug = $3`
+
+	result, err := tx.ExecContext(ctx, query, genre.Name, pq.Array(genre.Aliases), genre.Slug)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// Merge folds the source genre into the target in a single transaction:
+// every movie tagged with source is retagged with target (as a new movie
+// version), source and its aliases become aliases of target, and source is
+// deleted. It returns the number of movies rewritten.
+func (m GenreModel) Merge(source, target string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var sourceAliases []string
+
+	query := `SELECT aliases FROM genres WHERE slug = $1 FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, source).Scan(pq.Array(&sourceAliases))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	query = `UPDATE genres SET aliases = ARRAY(
+		SELECT DISTINCT alias FROM unnest(aliases || $2::text[] || ARRAY[$1::text]) AS alias
+	) WHERE slug = $3`
+
+	result, err := tx.ExecContext(ctx, query, source, pq.Array(sourceAliases), target)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if rowsAffected == 0 {
+		return 0, ErrRecordNotFound
+	}
+
+	query = `WITH updated AS (
+		UPDATE movies SET genres = CASE
+			WHEN $2 = ANY(genres) THEN array_remove(genres, $1)
+			ELSE array_replace(genres, $1, $2)
+		END, version = version + 1
+		WHERE $1 = ANY(genres)
+		RETURNING id, version, title, year, runtime, genres, external_ids
+	)
+	INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres, external_ids)
+	SELECT id, version, title, year, runtime, genres, external_ids FROM updated`
+
+	result, err = tx.ExecContext(ctx, query, source, target)
+	if err != nil {
+		return 0, err
+	}
+
+	movies, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM genres WHERE slug = $1`, source)
+	if err != nil {
+		return 0, err
+	}
+
+	return movies, tx.Commit()
+}
@@ -0,0 +1,151 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"greenlight.swsd2544.net/internal/errcode"
+	"greenlight.swsd2544.net/internal/validator"
+)
+
+// IdempotencyKeyTTL is how long a response is kept for retries to replay.
+const IdempotencyKeyTTL = 24 * time.Hour
+
+// IdempotencyKeyLease is how long a request may hold a key without
+// completing. A claim older than that was left behind by a request that
+// crashed or timed out, and is reclaimed by the next request with the key.
+const IdempotencyKeyLease = time.Minute
+
+// IdempotencyKey is a client-chosen key along with the response to the first
+// request made with it. Keys are scoped to the user who sent them. Anonymous
+// requests such as registration all have UserID 0, so their keys are scoped
+// to the Client they came from instead. Status is 0 while that first request
+// is still being handled.
+type IdempotencyKey struct {
+	CreatedAt   time.Time
+	Header      http.Header
+	Client      string
+	Key         string
+	Fingerprint []byte
+	Body        []byte
+	UserID      int64
+	Status      int
+}
+
+// InFlight reports whether the first request with the key hasn't completed.
+func (k *IdempotencyKey) InFlight() bool {
+	return k.Status == 0
+}
+
+var codeIdempotencyKeyTooLong = errcode.New("idempotency_key.too_long", "must not be more than 255 bytes long")
+
+func ValidateIdempotencyKey(v *validator.Validator, key string) {
+	v.Check(len(key) <= 255, "Idempotency-Key", codeIdempotencyKeyTooLong)
+}
+
+type IdempotencyKeyModel struct {
+	DB *sql.DB
+}
+
+// Begin claims the key for a request with the claim's fingerprint. It reports
+// whether the claim succeeded, filling in when it was made; when it didn't,
+// the key's existing record is returned instead. Expired keys and claims
+// whose lease has run out are reclaimed as if they had never been used.
+func (m IdempotencyKeyModel) Begin(claim *IdempotencyKey) (*IdempotencyKey, bool, error) {
+	query := `INSERT INTO idempotency_keys (user_id, client, key, fingerprint, expiry)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (user_id, client, key) DO UPDATE SET fingerprint = EXCLUDED.fingerprint,
+	status = NULL, header = '{}', body = NULL, created_at = NOW(), expiry = EXCLUDED.expiry
+	WHERE idempotency_keys.expiry < NOW()
+	OR (idempotency_keys.status IS NULL AND idempotency_keys.created_at < NOW() - make_interval(secs => $6))
+	RETURNING created_at`
+
+	args := []any{claim.UserID, claim.Client, claim.Key, claim.Fingerprint, time.Now().Add(IdempotencyKeyTTL), IdempotencyKeyLease.Seconds()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&claim.CreatedAt)
+	if err == nil {
+		return nil, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, err
+	}
+
+	record := IdempotencyKey{UserID: claim.UserID, Client: claim.Client, Key: claim.Key}
+
+	var status sql.NullInt32
+	var header []byte
+
+	query = `SELECT fingerprint, status, header, body, created_at FROM idempotency_keys
+	WHERE user_id = $1 AND client = $2 AND key = $3`
+
+	err = m.DB.QueryRowContext(ctx, query, claim.UserID, claim.Client, claim.Key).Scan(&record.Fingerprint, &status, &header, &record.Body, &record.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, false, ErrRecordNotFound
+		default:
+			return nil, false, err
+		}
+	}
+
+	record.Status = int(status.Int32)
+
+	err = json.Unmarshal(header, &record.Header)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &record, false, nil
+}
+
+// Complete stores the response to the request that claimed the key. A claim
+// that was reclaimed after its lease ran out is left to its new holder.
+func (m IdempotencyKeyModel) Complete(record *IdempotencyKey) error {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE idempotency_keys SET status = $1, header = $2, body = $3
+	WHERE user_id = $4 AND client = $5 AND key = $6 AND created_at = $7 AND status IS NULL`
+
+	args := []any{record.Status, header, record.Body, record.UserID, record.Client, record.Key, record.CreatedAt}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Release gives up the claim on the key, so that a retry is handled afresh.
+func (m IdempotencyKeyModel) Release(claim *IdempotencyKey) error {
+	query := `DELETE FROM idempotency_keys
+	WHERE user_id = $1 AND client = $2 AND key = $3 AND created_at = $4 AND status IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, claim.UserID, claim.Client, claim.Key, claim.CreatedAt)
+	return err
+}
+
+// DeleteExpired removes every key past its expiry and returns how many there
+// were.
+func (m IdempotencyKeyModel) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `DELETE FROM idempotency_keys WHERE expiry < NOW()`
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,11 @@
+package data
+
+// Link is a hypermedia link to a resource related to the one it is part of.
+type Link struct {
+	Href string `json:"href"`
+}
+
+// Links are the links of a resource keyed by their relation type, such as
+// "self" or "next". They are filled in by the API, which knows the URLs it is
+// served under.
+type Links map[string]Link
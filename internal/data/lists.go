@@ -0,0 +1,568 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.swsd2544.net/internal/errcode"
+	"greenlight.swsd2544.net/internal/validator"
+)
+
+const DefaultListName = "Watchlist"
+
+var ErrDuplicateEntry = errors.New("duplicate list entry")
+
+type List struct {
+	CreatedAt      time.Time `json:"created_at"`
+	Name           string    `json:"name"`
+	ShareTokenHash []byte    `json:"-"`
+	ID             int64     `json:"id"`
+	UserID         int64     `json:"user_id"`
+	EntryCount     int32     `json:"entry_count"`
+	Version        int32     `json:"version"`
+	Default        bool      `json:"default"`
+	Public         bool      `json:"public"`
+}
+
+// NewShareToken replaces the list's share token, invalidating any previous
+// link, and returns the plaintext of the new one. The list must then be saved
+// with ListModel.Update.
+func (l *List) NewShareToken() (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	hash := sha256.Sum256([]byte(plaintext))
+	l.ShareTokenHash = hash[:]
+
+	return plaintext, nil
+}
+
+type ListEntry struct {
+	AddedAt   time.Time `json:"added_at"`
+	WatchedOn *Date     `json:"watched_on,omitempty"`
+	Title     string    `json:"title"`
+	ListID    int64     `json:"list_id"`
+	MovieID   int64     `json:"movie_id"`
+	Year      int32     `json:"year"`
+	Position  int32     `json:"position"`
+}
+
+var (
+	codeListNameRequired           = errcode.New("list.name.required", "must be provided")
+	codeListNameTooLong            = errcode.New("list.name.too_long", "must not be more than 200 bytes long")
+	codeListEntryMovieIDRequired   = errcode.New("list.movie_id.required", "must be provided")
+	codeListEntryPositionNegative  = errcode.New("list.position.negative", "must not be negative")
+	codeListEntryWatchedOnInFuture = errcode.New("list.watched_on.in_future", "must not be in the future")
+)
+
+func ValidateList(v *validator.Validator, list *List) {
+	v.Check(list.Name != "", "name", codeListNameRequired)
+	v.Check(len(list.Name) <= 200, "name", codeListNameTooLong)
+}
+
+func ValidateListEntry(v *validator.Validator, entry *ListEntry) {
+	v.Check(entry.MovieID > 0, "movie_id", codeListEntryMovieIDRequired)
+	v.Check(entry.Position >= 0, "position", codeListEntryPositionNegative)
+
+	if entry.WatchedOn != nil {
+		v.Check(!time.Time(*entry.WatchedOn).After(time.Now()), "watched_on", codeListEntryWatchedOnInFuture)
+	}
+}
+
+type ListModel struct {
+	DB *sql.DB
+}
+
+// EnsureDefault creates the user's default watchlist if they don't have one
+// yet.
+func (m ListModel) EnsureDefault(userID int64) error {
+	query := `INSERT INTO lists (user_id, name, is_default) VALUES ($1, $2, true)
+	ON CONFLICT (user_id) WHERE is_default DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, DefaultListName)
+	return err
+}
+
+func (m ListModel) Insert(list *List) error {
+	query := `INSERT INTO lists (user_id, name, public)
+	VALUES ($1, $2, $3) RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, list.UserID, list.Name, list.Public).Scan(&list.ID, &list.CreatedAt, &list.Version)
+}
+
+const listColumns = `lists.id, lists.created_at, lists.user_id, lists.name, lists.is_default, lists.public,
+	lists.share_token_hash, lists.version,
+	(SELECT count(*) FROM list_entries INNER JOIN movies ON movies.id = list_entries.movie_id
+		WHERE list_entries.list_id = lists.id AND movies.deleted_at IS NULL)`
+
+func scanList(row interface{ Scan(...any) error }, list *List, extra ...any) error {
+	return row.Scan(append(extra,
+		&list.ID,
+		&list.CreatedAt,
+		&list.UserID,
+		&list.Name,
+		&list.Default,
+		&list.Public,
+		&list.ShareTokenHash,
+		&list.Version,
+		&list.EntryCount,
+	)...)
+}
+
+func (m ListModel) Get(id int64) (*List, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	var list List
+
+	query := `SELECT ` + listColumns + ` FROM lists WHERE lists.id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanList(m.DB.QueryRowContext(ctx, query, id), &list)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &list, nil
+}
+
+func (m ListModel) GetForShareToken(tokenPlaintext string) (*List, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	var list List
+
+	query := `SELECT ` + listColumns + ` FROM lists WHERE lists.share_token_hash = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanList(m.DB.QueryRowContext(ctx, query, tokenHash[:]), &list)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &list, nil
+}
+
+func (m ListModel) GetAllForUser(userID int64, filters Filters) ([]*List, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), %s FROM lists WHERE lists.user_id = $1
+	ORDER BY lists.is_default DESC, lists.%s %s, lists.id ASC LIMIT $2 OFFSET $3`,
+		listColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	totalRecords := 0
+	lists := []*List{}
+
+	for rows.Next() {
+		var list List
+
+		errScan := scanList(rows, &list, &totalRecords)
+		if errScan != nil {
+			return nil, Metadata{}, errScan
+		}
+
+		lists = append(lists, &list)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return lists, metadata, nil
+}
+
+func (m ListModel) Update(list *List) error {
+	query := `UPDATE lists SET name = $1, public = $2, share_token_hash = $3, version = version + 1
+	WHERE id = $4 AND version = $5 RETURNING version`
+
+	args := []any{list.Name, list.Public, list.ShareTokenHash, list.ID, list.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&list.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m ListModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM lists WHERE id = $1 AND NOT is_default`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetEntries lists the entries of a list, skipping movies in the trash.
+func (m ListModel) GetEntries(listID int64, filters Filters) ([]*ListEntry, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), list_entries.list_id, list_entries.movie_id,
+	movies.title, movies.year, list_entries.position, list_entries.added_at, list_entries.watched_on
+	FROM list_entries INNER JOIN movies ON movies.id = list_entries.movie_id
+	WHERE list_entries.list_id = $1 AND movies.deleted_at IS NULL
+	ORDER BY list_entries.%s %s NULLS LAST, list_entries.position ASC LIMIT $2 OFFSET $3`,
+		filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, listID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	totalRecords := 0
+	entries := []*ListEntry{}
+
+	for rows.Next() {
+		var entry ListEntry
+
+		errScan := rows.Scan(
+			&totalRecords,
+			&entry.ListID,
+			&entry.MovieID,
+			&entry.Title,
+			&entry.Year,
+			&entry.Position,
+			&entry.AddedAt,
+			&entry.WatchedOn,
+		)
+		if errScan != nil {
+			return nil, Metadata{}, errScan
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
+
+func (m ListModel) GetEntry(listID, movieID int64) (*ListEntry, error) {
+	if listID < 1 || movieID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	var entry ListEntry
+
+	query := `SELECT list_entries.list_id, list_entries.movie_id, movies.title, movies.year,
+	list_entries.position, list_entries.added_at, list_entries.watched_on
+	FROM list_entries INNER JOIN movies ON movies.id = list_entries.movie_id
+	WHERE list_entries.list_id = $1 AND list_entries.movie_id = $2 AND movies.deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, listID, movieID).Scan(
+		&entry.ListID,
+		&entry.MovieID,
+		&entry.Title,
+		&entry.Year,
+		&entry.Position,
+		&entry.AddedAt,
+		&entry.WatchedOn,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &entry, nil
+}
+
+// lockList locks the list's row for the rest of the transaction. Every change
+// to the positions of a list's entries takes this lock first, so that they
+// are worked out one after the other.
+func lockList(ctx context.Context, tx *sql.Tx, listID int64) error {
+	var id int64
+
+	err := tx.QueryRowContext(ctx, `SELECT id FROM lists WHERE id = $1 FOR UPDATE`, listID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lockListsOf locks the lists holding any of the movies matching where, in id
+// order so that concurrent callers can't deadlock, and returns their ids.
+func lockListsOf(ctx context.Context, tx *sql.Tx, where string, args ...any) ([]int64, error) {
+	query := `SELECT id FROM lists WHERE id IN (SELECT list_entries.list_id FROM list_entries
+	WHERE list_entries.movie_id IN (SELECT id FROM movies WHERE ` + where + `))
+	ORDER BY id FOR UPDATE`
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var listIDs []int64
+
+	for rows.Next() {
+		var id int64
+
+		errScan := rows.Scan(&id)
+		if errScan != nil {
+			return nil, errScan
+		}
+
+		listIDs = append(listIDs, id)
+	}
+
+	return listIDs, rows.Err()
+}
+
+// renumberListEntries numbers the entries of the lists from 1 again, keeping
+// their order, to close the gaps left by movies deleted from under them. The
+// caller must hold the lists' locks.
+func renumberListEntries(ctx context.Context, tx *sql.Tx, listIDs []int64) error {
+	if len(listIDs) == 0 {
+		return nil
+	}
+
+	query := `UPDATE list_entries SET position = renumbered.position
+	FROM (SELECT list_id, movie_id, row_number() OVER (PARTITION BY list_id ORDER BY position) AS position
+		FROM list_entries WHERE list_id = ANY($1)) renumbered
+	WHERE list_entries.list_id = renumbered.list_id AND list_entries.movie_id = renumbered.movie_id
+	AND list_entries.position <> renumbered.position`
+
+	_, err := tx.ExecContext(ctx, query, pq.Array(listIDs))
+	return err
+}
+
+// AddEntry appends a movie to the end of a list.
+func (m ListModel) AddEntry(entry *ListEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	err = lockList(ctx, tx, entry.ListID)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO list_entries (list_id, movie_id, position, watched_on)
+	SELECT $1, $2, COALESCE(max(position), 0) + 1, $3 FROM list_entries WHERE list_id = $1
+	RETURNING position, added_at`
+
+	err = tx.QueryRowContext(ctx, query, entry.ListID, entry.MovieID, entry.WatchedOn).Scan(&entry.Position, &entry.AddedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "list_entries_pkey"`:
+			return ErrDuplicateEntry
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UpdateEntry saves the entry's watched date and moves it to entry.Position,
+// shifting the entries in between. Positions past the end of the list are
+// clamped to the last slot.
+func (m ListModel) UpdateEntry(entry *ListEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	err = lockList(ctx, tx, entry.ListID)
+	if err != nil {
+		return err
+	}
+
+	var current, last int32
+
+	query := `SELECT position, (SELECT max(position) FROM list_entries WHERE list_id = $1)
+	FROM list_entries WHERE list_id = $1 AND movie_id = $2 FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, entry.ListID, entry.MovieID).Scan(&current, &last)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if entry.Position < 1 || entry.Position > last {
+		entry.Position = last
+	}
+
+	switch {
+	case entry.Position > current:
+		query = `UPDATE list_entries SET position = position - 1
+		WHERE list_id = $1 AND position > $2 AND position <= $3`
+		_, err = tx.ExecContext(ctx, query, entry.ListID, current, entry.Position)
+	case entry.Position < current:
+		query = `UPDATE list_entries SET position = position + 1
+		WHERE list_id = $1 AND position >= $3 AND position < $2`
+		_, err = tx.ExecContext(ctx, query, entry.ListID, current, entry.Position)
+	}
+	if err != nil {
+		return err
+	}
+
+	query = `UPDATE list_entries SET position = $1, watched_on = $2 WHERE list_id = $3 AND movie_id = $4`
+
+	_, err = tx.ExecContext(ctx, query, entry.Position, entry.WatchedOn, entry.ListID, entry.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveEntry deletes a movie from a list and closes the gap it leaves in the
+// ordering.
+func (m ListModel) RemoveEntry(listID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	err = lockList(ctx, tx, listID)
+	if err != nil {
+		return err
+	}
+
+	var position int32
+
+	query := `DELETE FROM list_entries WHERE list_id = $1 AND movie_id = $2 RETURNING position`
+
+	err = tx.QueryRowContext(ctx, query, listID, movieID).Scan(&position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	query = `UPDATE list_entries SET position = position - 1 WHERE list_id = $1 AND position > $2`
+
+	_, err = tx.ExecContext(ctx, query, listID, position)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -11,17 +11,37 @@ var (
 )
 
 type Models struct {
-	Movies      MovieModel
-	Permissions PermissionModel
-	Tokens      TokenModel
-	Users       UserModel
+	Collections       CollectionModel
+	Credits           CreditModel
+	Genres            GenreModel
+	IdempotencyKeys   IdempotencyKeyModel
+	Lists             ListModel
+	Movies            MovieModel
+	MovieRevisions    MovieRevisionModel
+	MovieTranslations MovieTranslationModel
+	People            PersonModel
+	Permissions       PermissionModel
+	Reviews           ReviewModel
+	Similarities      SimilarityModel
+	Tokens            TokenModel
+	Users             UserModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
+		Collections:       CollectionModel{DB: db},
+		Credits:           CreditModel{DB: db},
+		Genres:            GenreModel{DB: db},
+		IdempotencyKeys:   IdempotencyKeyModel{DB: db},
+		Lists:             ListModel{DB: db},
+		Movies:            MovieModel{DB: db},
+		MovieRevisions:    MovieRevisionModel{DB: db},
+		MovieTranslations: MovieTranslationModel{DB: db},
+		People:            PersonModel{DB: db},
+		Permissions:       PermissionModel{DB: db},
+		Reviews:           ReviewModel{DB: db},
+		Similarities:      SimilarityModel{DB: db},
+		Tokens:            TokenModel{DB: db},
+		Users:             UserModel{DB: db},
 	}
 }
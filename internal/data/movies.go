@@ -5,77 +5,240 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
+	"greenlight.swsd2544.net/internal/errcode"
 	"greenlight.swsd2544.net/internal/validator"
 )
 
 type Movie struct {
-	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title"`
-	Genres    []string  `json:"genres,omitempty"`
-	ID        int64     `json:"id"`
-	Year      int32     `json:"year,omitempty"`
-	Runtime   Runtime   `json:"runtime,omitempty"`
-	Version   int32     `json:"version"`
+	CreatedAt     time.Time          `json:"-"`
+	DeletedAt     *time.Time         `json:"deleted_at,omitempty"`
+	Title         string             `json:"title" validate:"required,max=500"`
+	OriginalTitle string             `json:"original_title,omitempty"`
+	Synopsis      string             `json:"synopsis,omitempty"`
+	Genres        []string           `json:"genres,omitempty" validate:"required,min=1,max=5,unique"`
+	ExternalIDs   ExternalIDs        `json:"external_ids,omitempty"`
+	Collections   []*MovieCollection `json:"collections,omitempty"`
+	Credits       []*Credit          `json:"credits,omitempty"`
+	ReviewSummary *ReviewSummary     `json:"review_summary,omitempty"`
+	Links         Links              `json:"_links,omitempty"`
+	ID            int64              `json:"id"`
+	Rating        float64            `json:"rating"`
+	Year          int32              `json:"year,omitempty" validate:"required,min=1888@too_early"`
+	Runtime       Runtime            `json:"runtime,omitempty" validate:"required,min=1@not_positive"`
+	Votes         int32              `json:"votes"`
+	Version       int32              `json:"version"`
+	PosterKey     string             `json:"-"`
+	PosterURL     string             `json:"poster_url,omitempty"`
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
-	v.Check(movie.Title != "", "title", "must be provided")
-	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
+var movieRules = validator.NewRules("movie", Movie{})
 
-	v.Check(movie.Year != 0, "year", "must be provided")
-	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
-	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
+// MovieFields lists the fields of a movie that a sparse fieldset can select,
+// in the order their columns are read.
+var MovieFields = []string{
+	"id", "title", "original_title", "synopsis", "year", "runtime", "genres",
+	"external_ids", "rating", "votes", "version", "poster_url",
+}
+
+// movieColumns maps the selectable movie fields that are stored on the movies
+// table to their column. The original title is the title column itself, and
+// the synopsis only comes from translations.
+var movieColumns = map[string]string{
+	"id":             "id",
+	"title":          "title",
+	"original_title": "title",
+	"year":           "year",
+	"runtime":        "runtime",
+	"genres":         "genres",
+	"external_ids":   "external_ids",
+	"rating":         "rating",
+	"votes":          "votes",
+	"version":        "version",
+	"poster_url":     "poster_url",
+}
+
+// movieSelection returns the columns to read for a sparse fieldset along with
+// where each is scanned into movie. The id is always read, since related
+// resources are embedded by it. No fields selects every column.
+func movieSelection(fields []string, movie *Movie) (string, []any) {
+	dests := map[string]any{
+		"id":           &movie.ID,
+		"created_at":   &movie.CreatedAt,
+		"title":        &movie.Title,
+		"year":         &movie.Year,
+		"runtime":      &movie.Runtime,
+		"genres":       pq.Array(&movie.Genres),
+		"rating":       &movie.Rating,
+		"votes":        &movie.Votes,
+		"version":      &movie.Version,
+		"poster_key":   &movie.PosterKey,
+		"poster_url":   &movie.PosterURL,
+		"external_ids": &movie.ExternalIDs,
+	}
+
+	columns := []string{
+		"id", "created_at", "title", "year", "runtime", "genres", "rating",
+		"votes", "version", "poster_key", "poster_url", "external_ids",
+	}
+
+	if len(fields) > 0 {
+		columns = []string{"id"}
+
+		for _, field := range MovieFields {
+			column, ok := movieColumns[field]
+			if !ok || !validator.PermittedValue(field, fields...) || validator.PermittedValue(column, columns...) {
+				continue
+			}
+			columns = append(columns, column)
+		}
+	}
+
+	scan := make([]any, len(columns))
+	for i, column := range columns {
+		scan[i] = dests[column]
+	}
+
+	return strings.Join(columns, ", "), scan
+}
+
+var (
+	codeMovieYearInFuture  = errcode.New("movie.year.in_future", "must not be in the future")
+	codeMovieGenresUnknown = errcode.New("movie.genres.unknown", "contains unknown genre \"{genre}\"")
+)
+
+// ValidateMovie checks the movie's fields and rewrites its genres to their
+// canonical slugs from the catalog. Genres the catalog doesn't know are
+// rejected. Genres are canonicalized before the declared rules run, so
+// aliases of the same genre count as duplicates.
+func ValidateMovie(v *validator.Validator, movie *Movie, genres GenreCatalog) {
+	for i, genre := range movie.Genres {
+		slug, ok := genres.Canonical(genre)
+		if !ok {
+			v.AddError(validator.Path("genres", i), codeMovieGenresUnknown, errcode.Params{"genre": genre})
+			continue
+		}
+		movie.Genres[i] = slug
+	}
+
+	movieRules.Validate(v, movie)
+
+	v.Check(movie.Year <= int32(time.Now().Year()), "year", codeMovieYearInFuture)
+
+	ValidateExternalIDs(v, movie.ExternalIDs)
+}
 
-	v.Check(movie.Runtime != 0, "runtime", "must be provided")
-	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
+// MovieCriteria narrows down the movies returned by GetAll and Export. Zero
+// values don't filter anything.
+type MovieCriteria struct {
+	Title    string
+	Director string
+	Actor    string
+	Genres   []string
+}
 
-	v.Check(movie.Genres != nil, "genres", "must be provided")
-	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
-	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
-	v.Check(validator.Unique(movie.Genres), "genres", "must not contain dupliate values")
+// where returns the SQL condition matching the criteria along with its
+// arguments, numbered from $1. Titles match the original or any translation,
+// each searched with its own locale's text-search configuration; titles
+// indexed with "simple" (Thai, which has no word boundaries) also match by
+// substring. Trashed movies never match.
+func (c MovieCriteria) where() (string, []any) {
+	condition := `($1 = '' OR to_tsvector('simple', title) @@ plainto_tsquery('simple', $1)
+		OR EXISTS (SELECT 1 FROM movie_translations WHERE movie_translations.movie_id = movies.id
+		AND (movie_translations.title_tsv @@ plainto_tsquery(movie_translations.search_config, $1)
+		OR (movie_translations.search_config = 'simple' AND strpos(lower(movie_translations.title), lower($1)) > 0))))
+	AND (genres @> $2 OR $2 = '{}')
+	AND ($3 = '' OR EXISTS (SELECT 1 FROM movie_credits INNER JOIN people ON people.id = movie_credits.person_id
+		WHERE movie_credits.movie_id = movies.id AND movie_credits.role = 'director'
+		AND to_tsvector('simple', people.name) @@ plainto_tsquery('simple', $3)))
+	AND ($4 = '' OR EXISTS (SELECT 1 FROM movie_credits INNER JOIN people ON people.id = movie_credits.person_id
+		WHERE movie_credits.movie_id = movies.id AND movie_credits.role = 'actor'
+		AND to_tsvector('simple', people.name) @@ plainto_tsquery('simple', $4)))
+	AND deleted_at IS NULL`
+
+	return condition, []any{c.Title, pq.Array(c.Genres), c.Director, c.Actor}
 }
 
 type MovieModel struct {
 	DB *sql.DB
 }
 
-func (m MovieModel) Insert(movie *Movie) error {
-	query := `INSERT INTO movies (title, year, runtime, genres)
-	VALUES ($1, $2, $3, $4) RETURNING id, created_at, version`
+// Insert creates the movie. Unless allowDuplicates is set, it fails with
+// ErrDuplicateMovie when a movie with the same normalized title and year
+// exists, checking under a lock so that concurrent inserts of the same film
+// can't both get in.
+func (m MovieModel) Insert(movie *Movie, allowDuplicates bool) error {
+	query := `INSERT INTO movies (title, title_key, year, runtime, genres, external_ids)
+	VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, version`
 
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []any{movie.Title, NormalizeTitle(movie.Title), movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.ExternalIDs}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if !allowDuplicates {
+		err = lockTitleKey(ctx, tx, movie)
+		if err != nil {
+			return err
+		}
+
+		duplicate, err := hasDuplicate(ctx, tx, movie)
+		if err != nil {
+			return err
+		}
+
+		if duplicate {
+			return ErrDuplicateMovie
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		switch {
+		case isDuplicateExternalID(err):
+			return ErrDuplicateExternalID
+		default:
+			return err
+		}
+	}
+
+	err = recordRevision(ctx, tx, movie.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (m MovieModel) Get(id int64) (*Movie, error) {
+// Get returns the movie outside the trash with the given id. When fields are
+// given, only the columns backing them are read; see MovieFields.
+func (m MovieModel) Get(id int64, fields ...string) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
 	var movie Movie
 
-	query := `SELECT id, created_at, title, year, runtime,
-	genres, version FROM movies WHERE id = $1`
+	columns, dest := movieSelection(fields, &movie)
+
+	query := fmt.Sprintf(`SELECT %s FROM movies WHERE id = $1 AND deleted_at IS NULL`, columns)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&movie.ID,
-		&movie.CreatedAt,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		pq.Array(&movie.Genres),
-		&movie.Version,
-	)
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(dest...)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -90,7 +253,8 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 
 func (m MovieModel) Update(movie *Movie) error {
 	query := `UPDATE movies SET title = $1, year = $2, runtime = $3,
-	genres = $4, version = version + 1 WHERE id = $5 AND version = $6
+	genres = $4, title_key = $7, external_ids = $8, version = version + 1 WHERE id = $5 AND version = $6
+	AND deleted_at IS NULL
 	RETURNING version`
 
 	args := []any{
@@ -100,30 +264,50 @@ func (m MovieModel) Update(movie *Movie) error {
 		pq.Array(movie.Genres),
 		movie.ID,
 		movie.Version,
+		NormalizeTitle(movie.Title),
+		movie.ExternalIDs,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
+		case isDuplicateExternalID(err):
+			return ErrDuplicateExternalID
 		default:
 			return err
 		}
 	}
 
-	return nil
+	err = recordRevision(ctx, tx, movie.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
+// Delete moves the movie to the trash. Trashed movies are hidden from Get,
+// GetAll and Update until they are restored or purged.
 func (m MovieModel) Delete(id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	query := `DELETE FROM movies WHERE id = $1`
+	query := `UPDATE movies SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -145,12 +329,46 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
-	query := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version 
-	FROM movies WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-	AND (genres @> $2 OR $2 = '{}') ORDER BY %s %s, id ASC LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+// DeleteVersion moves the movie to the trash only if it is still at the given
+// version, returning ErrEditConflict otherwise.
+func (m MovieModel) DeleteVersion(id int64, version int32) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `UPDATE movies SET deleted_at = NOW()
+	WHERE id = $1 AND version = $2 AND deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, version)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+func (m MovieModel) GetAll(criteria MovieCriteria, filters Filters, fields ...string) ([]*Movie, Metadata, error) {
+	where, args := criteria.where()
+
+	columns, _ := movieSelection(fields, &Movie{})
 
-	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
+	query := fmt.Sprintf(`SELECT count(*) OVER(), %s
+	FROM movies WHERE %s ORDER BY %s %s, id ASC LIMIT $%d OFFSET $%d`,
+		columns, where, filters.sortColumn(), filters.sortDirection(), len(args)+1, len(args)+2)
+
+	args = append(args, filters.limit(), filters.offset())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -167,6 +385,48 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	totalRecords := 0
 	var movies []*Movie
 
+	for rows.Next() {
+		var movie Movie
+
+		_, dest := movieSelection(fields, &movie)
+
+		errScan := rows.Scan(append([]any{&totalRecords}, dest...)...)
+		if errScan != nil {
+			return nil, Metadata{}, errScan
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+func (m MovieModel) GetAllDeleted(filters Filters) ([]*Movie, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, deleted_at, title, year, runtime, genres, version,
+	poster_key, poster_url, external_ids
+	FROM movies WHERE deleted_at IS NOT NULL ORDER BY %s %s, id ASC LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	totalRecords := 0
+	var movies []*Movie
+
 	for rows.Next() {
 		var movie Movie
 
@@ -174,11 +434,15 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&totalRecords,
 			&movie.ID,
 			&movie.CreatedAt,
+			&movie.DeletedAt,
 			&movie.Title,
 			&movie.Year,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.PosterKey,
+			&movie.PosterURL,
+			&movie.ExternalIDs,
 		)
 		if errScan != nil {
 			return nil, Metadata{}, errScan
@@ -195,3 +459,266 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 
 	return movies, metadata, nil
 }
+
+// UpdatePoster records the storage key and public URL of the movie's poster.
+// Posters aren't part of the movie's revision history, so the version is left
+// alone.
+func (m MovieModel) UpdatePoster(movie *Movie) error {
+	query := `UPDATE movies SET poster_key = $1, poster_url = $2 WHERE id = $3 AND deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movie.PosterKey, movie.PosterURL, movie.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Restore takes a movie back out of the trash. It fails with
+// ErrDuplicateExternalID if a live movie has since taken one of its
+// identifiers.
+func (m MovieModel) Restore(id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	var movie Movie
+
+	query := `UPDATE movies SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL
+	RETURNING id, created_at, title, year, runtime, genres, rating, votes, version, poster_key, poster_url,
+	external_ids`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Rating,
+		&movie.Votes,
+		&movie.Version,
+		&movie.PosterKey,
+		&movie.PosterURL,
+		&movie.ExternalIDs,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		case isDuplicateExternalID(err):
+			return nil, ErrDuplicateExternalID
+		default:
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
+
+// Purge permanently deletes a movie that is already in the trash, closing the
+// gaps it leaves in the lists it was on. It returns the key of the movie's
+// poster, if any, for the caller to remove from storage.
+func (m MovieModel) Purge(id int64) (string, error) {
+	if id < 1 {
+		return "", ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	listIDs, err := lockListsOf(ctx, tx, `id = $1 AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		return "", err
+	}
+
+	var posterKey string
+
+	query := `DELETE FROM movies WHERE id = $1 AND deleted_at IS NOT NULL RETURNING poster_key`
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&posterKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	err = renumberListEntries(ctx, tx, listIDs)
+	if err != nil {
+		return "", err
+	}
+
+	return posterKey, tx.Commit()
+}
+
+// PurgeDeletedBefore permanently deletes every movie that was moved to the
+// trash before cutoff and returns how many were removed, along with the keys
+// of their posters for the caller to remove from storage. The gaps they leave
+// in lists are closed.
+func (m MovieModel) PurgeDeletedBefore(cutoff time.Time) (int64, []string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	listIDs, err := lockListsOf(ctx, tx, `deleted_at < $1`, cutoff)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	query := `DELETE FROM movies WHERE deleted_at < $1 RETURNING poster_key`
+
+	rows, err := tx.QueryContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var purged int64
+	var posterKeys []string
+
+	for rows.Next() {
+		var posterKey string
+
+		errScan := rows.Scan(&posterKey)
+		if errScan != nil {
+			return 0, nil, errScan
+		}
+
+		purged++
+		if posterKey != "" {
+			posterKeys = append(posterKeys, posterKey)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	err = renumberListEntries(ctx, tx, listIDs)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return purged, posterKeys, tx.Commit()
+}
+
+// exportBatchSize is the number of rows fetched from the export cursor per
+// round trip.
+const exportBatchSize = 500
+
+// Export streams every movie matching criteria to fn, ordered by
+// filters. Rows are read in batches through a server-side cursor, so the
+// result set is never held in memory and the paging fields of filters are
+// ignored. Returning an error from fn stops the export.
+func (m MovieModel) Export(ctx context.Context, criteria MovieCriteria, filters Filters, fn func(*Movie) error) error {
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	where, args := criteria.where()
+
+	query := fmt.Sprintf(`DECLARE movies_export NO SCROLL CURSOR FOR
+	SELECT id, created_at, title, year, runtime, genres, version
+	FROM movies WHERE %s ORDER BY %s %s, id ASC`, where, filters.sortColumn(), filters.sortDirection())
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	fetch := fmt.Sprintf(`FETCH FORWARD %d FROM movies_export`, exportBatchSize)
+
+	for {
+		fetched, err := m.exportBatch(ctx, tx, fetch, fn)
+		if err != nil {
+			return err
+		}
+
+		if fetched < exportBatchSize {
+			break
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m MovieModel) exportBatch(ctx context.Context, tx *sql.Tx, fetch string, fn func(*Movie) error) (int, error) {
+	rows, err := tx.QueryContext(ctx, fetch)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	fetched := 0
+
+	for rows.Next() {
+		var movie Movie
+
+		errScan := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if errScan != nil {
+			return fetched, errScan
+		}
+
+		fetched++
+
+		if errFn := fn(&movie); errFn != nil {
+			return fetched, errFn
+		}
+	}
+
+	return fetched, rows.Err()
+}
@@ -0,0 +1,345 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.swsd2544.net/internal/errcode"
+	"greenlight.swsd2544.net/internal/validator"
+)
+
+const (
+	ReviewPending   = "pending"
+	ReviewPublished = "published"
+	ReviewRejected  = "rejected"
+)
+
+var ReviewStatuses = []string{ReviewPending, ReviewPublished, ReviewRejected}
+
+var ErrDuplicateReview = errors.New("duplicate review")
+
+type Review struct {
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Body      string    `json:"body,omitempty"`
+	Status    string    `json:"status"`
+	UserName  string    `json:"user_name"`
+	ID        int64     `json:"id"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	Rating    int32     `json:"rating"`
+	Version   int32     `json:"version"`
+}
+
+// ReviewSummary aggregates the published reviews of a movie. Distribution
+// counts the reviews per rating and only lists ratings that were given.
+type ReviewSummary struct {
+	Distribution map[int32]int64 `json:"distribution"`
+	Average      float64         `json:"average"`
+	Count        int64           `json:"count"`
+}
+
+var (
+	codeReviewRatingRequired   = errcode.New("review.rating.required", "must be provided")
+	codeReviewRatingOutOfRange = errcode.New("review.rating.out_of_range", "must be between 1 and 10")
+	codeReviewBodyTooLong      = errcode.New("review.body.too_long", "must not be more than 10000 bytes long")
+	codeReviewStatusInvalid    = errcode.New("review.status.invalid", "must be one of pending, published or rejected")
+)
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating != 0, "rating", codeReviewRatingRequired)
+	v.Check(review.Rating >= 1 && review.Rating <= 10, "rating", codeReviewRatingOutOfRange)
+	v.Check(len(review.Body) <= 10_000, "body", codeReviewBodyTooLong)
+}
+
+func ValidateReviewStatus(v *validator.Validator, status string) {
+	v.Check(validator.PermittedValue(status, ReviewStatuses...), "status", codeReviewStatusInvalid)
+}
+
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+// updateMovieRating recomputes the movie's average rating and vote count from
+// its published reviews. It runs in the same transaction as the review write
+// so the aggregate never drifts from the reviews table. The movie row is locked
+// first, so that concurrent review writes recompute one after the other and
+// the last one to commit sees every review.
+func updateMovieRating(ctx context.Context, tx *sql.Tx, movieID int64) error {
+	_, err := tx.ExecContext(ctx, `SELECT id FROM movies WHERE id = $1 FOR UPDATE`, movieID)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE movies SET
+	rating = COALESCE((SELECT avg(rating) FROM reviews WHERE movie_id = $1 AND status = 'published'), 0),
+	votes = (SELECT count(*) FROM reviews WHERE movie_id = $1 AND status = 'published')
+	WHERE id = $1`
+
+	_, err = tx.ExecContext(ctx, query, movieID)
+	return err
+}
+
+func (m ReviewModel) Insert(review *Review) error {
+	query := `INSERT INTO reviews (movie_id, user_id, rating, body, status)
+	VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, updated_at, version`
+
+	args := []any{review.MovieID, review.UserID, review.Rating, review.Body, review.Status}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.UpdatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "reviews_movie_id_user_id_key"`:
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+
+	err = updateMovieRating(ctx, tx, review.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m ReviewModel) Get(movieID, id int64) (*Review, error) {
+	if movieID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	var review Review
+
+	query := `SELECT reviews.id, reviews.created_at, reviews.updated_at, reviews.movie_id, reviews.user_id,
+	users.name, reviews.rating, reviews.body, reviews.status, reviews.version
+	FROM reviews INNER JOIN users ON users.id = reviews.user_id
+	WHERE reviews.movie_id = $1 AND reviews.id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, id).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.UserName,
+		&review.Rating,
+		&review.Body,
+		&review.Status,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// Update saves the rating, body and status of the review and refreshes the
+// movie's aggregate rating.
+func (m ReviewModel) Update(review *Review) error {
+	query := `UPDATE reviews SET rating = $1, body = $2, status = $3, updated_at = NOW(),
+	version = version + 1 WHERE id = $4 AND version = $5
+	RETURNING updated_at, version`
+
+	args := []any{review.Rating, review.Body, review.Status, review.ID, review.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.UpdatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = updateMovieRating(ctx, tx, review.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m ReviewModel) Delete(review *Review) error {
+	query := `DELETE FROM reviews WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	result, err := tx.ExecContext(ctx, query, review.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = updateMovieRating(ctx, tx, review.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetAllForMovie lists the movie's reviews in the given status.
+func (m ReviewModel) GetAllForMovie(movieID int64, status string, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), reviews.id, reviews.created_at, reviews.updated_at,
+	reviews.movie_id, reviews.user_id, users.name, reviews.rating, reviews.body, reviews.status, reviews.version
+	FROM reviews INNER JOIN users ON users.id = reviews.user_id
+	WHERE reviews.movie_id = $1 AND reviews.status = $2
+	ORDER BY reviews.%s %s, reviews.id ASC LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	args := []any{movieID, status, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		errScan := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.UserName,
+			&review.Rating,
+			&review.Body,
+			&review.Status,
+			&review.Version,
+		)
+		if errScan != nil {
+			return nil, Metadata{}, errScan
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+// EmbedInMovies sets ReviewSummary on each of the movies from its published
+// reviews.
+func (m ReviewModel) EmbedInMovies(movies ...*Movie) error {
+	if len(movies) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*Movie, len(movies))
+	ids := make([]int64, 0, len(movies))
+
+	for _, movie := range movies {
+		movie.ReviewSummary = &ReviewSummary{Distribution: map[int32]int64{}}
+		byID[movie.ID] = movie
+		ids = append(ids, movie.ID)
+	}
+
+	query := `SELECT movie_id, rating, count(*) FROM reviews
+	WHERE movie_id = ANY($1) AND status = $2
+	GROUP BY movie_id, rating`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids), ReviewPublished)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var movieID, count int64
+		var rating int32
+
+		errScan := rows.Scan(&movieID, &rating, &count)
+		if errScan != nil {
+			return errScan
+		}
+
+		if movie, ok := byID[movieID]; ok {
+			summary := movie.ReviewSummary
+			summary.Average = (summary.Average*float64(summary.Count) + float64(rating)*float64(count)) / float64(summary.Count+count)
+			summary.Distribution[rating] = count
+			summary.Count += count
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,151 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MovieRevision is a snapshot of a movie's editable fields as they were at a
+// given version. ExternalIDs is nil in revisions recorded before external IDs
+// were snapshotted, as they aren't known.
+type MovieRevision struct {
+	CreatedAt   time.Time   `json:"created_at"`
+	Title       string      `json:"title"`
+	Genres      []string    `json:"genres"`
+	ExternalIDs ExternalIDs `json:"external_ids"`
+	MovieID     int64       `json:"movie_id"`
+	Year        int32       `json:"year"`
+	Runtime     Runtime     `json:"runtime"`
+	Version     int32       `json:"version"`
+}
+
+type FieldChange struct {
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+	Field string `json:"field"`
+}
+
+// DiffRevisions lists the fields that differ between two revisions.
+func DiffRevisions(from, to *MovieRevision) []FieldChange {
+	changes := []FieldChange{}
+
+	add := func(field string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			changes = append(changes, FieldChange{Field: field, From: a, To: b})
+		}
+	}
+
+	add("title", from.Title, to.Title)
+	add("year", from.Year, to.Year)
+	add("runtime", from.Runtime.Format(RuntimeFormatMins), to.Runtime.Format(RuntimeFormatMins))
+	add("genres", from.Genres, to.Genres)
+	if from.ExternalIDs != nil && to.ExternalIDs != nil {
+		add("external_ids", from.ExternalIDs, to.ExternalIDs)
+	}
+
+	return changes
+}
+
+// recordRevision snapshots the current state of a movie into movie_revisions.
+// It must run in the same transaction as the write that produced the version.
+func recordRevision(ctx context.Context, tx *sql.Tx, movieID int64) error {
+	query := `INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres, external_ids)
+	SELECT id, version, title, year, runtime, genres, external_ids FROM movies WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, query, movieID)
+	return err
+}
+
+type MovieRevisionModel struct {
+	DB *sql.DB
+}
+
+func (m MovieRevisionModel) Get(movieID int64, version int32) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	var revision MovieRevision
+
+	query := `SELECT movie_id, version, created_at, title, year, runtime, genres, external_ids
+	FROM movie_revisions WHERE movie_id = $1 AND version = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(
+		&revision.MovieID,
+		&revision.Version,
+		&revision.CreatedAt,
+		&revision.Title,
+		&revision.Year,
+		&revision.Runtime,
+		pq.Array(&revision.Genres),
+		&revision.ExternalIDs,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
+
+func (m MovieRevisionModel) GetAllForMovie(movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), movie_id, version, created_at, title, year, runtime, genres, external_ids
+	FROM movie_revisions WHERE movie_id = $1 ORDER BY %s %s LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var revision MovieRevision
+
+		errScan := rows.Scan(
+			&totalRecords,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.CreatedAt,
+			&revision.Title,
+			&revision.Year,
+			&revision.Runtime,
+			pq.Array(&revision.Genres),
+			&revision.ExternalIDs,
+		)
+		if errScan != nil {
+			return nil, Metadata{}, errScan
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
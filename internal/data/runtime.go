@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+
+	"greenlight.swsd2544.net/internal/errcode"
+	"greenlight.swsd2544.net/internal/validator"
 )
 
 var ErrInvalidRuntimeFormat = errors.New("invalid runtime format")
@@ -40,3 +43,32 @@ func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
 
 	return nil
 }
+
+const (
+	RuntimeFormatMins    = "mins"
+	RuntimeFormatMinutes = "minutes"
+	RuntimeFormatHours   = "hours"
+)
+
+var RuntimeFormats = []string{RuntimeFormatMins, RuntimeFormatMinutes, RuntimeFormatHours}
+
+var (
+	codeRuntimeFormatInvalid = errcode.New("runtime_format.invalid", "must be one of mins, minutes or hours")
+)
+
+func ValidateRuntimeFormat(v *validator.Validator, format string) {
+	v.Check(validator.PermittedValue(format, RuntimeFormats...), "runtime_format", codeRuntimeFormatInvalid)
+}
+
+// Format renders the runtime as "102 mins" (the JSON representation),
+// "102" or "1h42m" depending on format.
+func (r Runtime) Format(format string) string {
+	switch format {
+	case RuntimeFormatMinutes:
+		return strconv.FormatInt(int64(r), 10)
+	case RuntimeFormatHours:
+		return fmt.Sprintf("%dh%02dm", r/60, r%60)
+	default:
+		return fmt.Sprintf("%d mins", r)
+	}
+}
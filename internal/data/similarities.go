@@ -0,0 +1,193 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// similarityYearSpan is the gap in years at which year proximity stops
+	// contributing to a similarity score.
+	similarityYearSpan = 20
+	// similarityCreditCap is the number of shared cast and crew members at
+	// which the shared-credits component is maxed out.
+	similarityCreditCap = 5
+)
+
+// SimilarityWeights weigh the components of the score that ranks how alike
+// two movies are. Each component is between 0 and 1.
+type SimilarityWeights struct {
+	// Genres weighs the Jaccard index of the two movies' genres.
+	Genres float64
+	// Year weighs how close the release years are.
+	Year float64
+	// Credits weighs how many people are credited on both movies.
+	Credits float64
+	// CoOccurrence weighs how often users put both movies in their lists or
+	// review both, relative to each movie's audience.
+	CoOccurrence float64
+}
+
+type SimilarMovie struct {
+	*Movie
+	Score float64 `json:"score"`
+}
+
+type SimilarityModel struct {
+	DB *sql.DB
+}
+
+// Recompute replaces the stored similar movies of every movie with the top
+// limit candidates under the given weights. Only movies that share a genre, a
+// credit or an audience with each other are scored.
+func (m SimilarityModel) Recompute(ctx context.Context, weights SimilarityWeights, limit int) (int64, error) {
+	query := `WITH live AS (
+		SELECT id, year, genres FROM movies WHERE deleted_at IS NULL
+	),
+	engagement AS (
+		SELECT lists.user_id, list_entries.movie_id FROM list_entries INNER JOIN lists ON lists.id = list_entries.list_id
+		UNION
+		SELECT user_id, movie_id FROM reviews WHERE status = 'published'
+	),
+	audience AS (
+		SELECT movie_id, count(*) AS users FROM engagement GROUP BY movie_id
+	),
+	cooccurrence AS (
+		SELECT a.movie_id, b.movie_id AS similar_id, count(*) AS users
+		FROM engagement a INNER JOIN engagement b ON a.user_id = b.user_id AND a.movie_id <> b.movie_id
+		GROUP BY a.movie_id, b.movie_id
+	),
+	shared_credits AS (
+		SELECT a.movie_id, b.movie_id AS similar_id, count(DISTINCT a.person_id) AS people
+		FROM movie_credits a INNER JOIN movie_credits b ON a.person_id = b.person_id AND a.movie_id <> b.movie_id
+		GROUP BY a.movie_id, b.movie_id
+	),
+	candidates AS (
+		SELECT a.id AS movie_id, b.id AS similar_id FROM live a INNER JOIN live b ON a.id <> b.id AND a.genres && b.genres
+		UNION
+		SELECT movie_id, similar_id FROM cooccurrence
+		UNION
+		SELECT movie_id, similar_id FROM shared_credits
+	),
+	scored AS (
+		SELECT candidates.movie_id, candidates.similar_id,
+			$1 * (SELECT count(*) FROM (SELECT unnest(a.genres) INTERSECT SELECT unnest(b.genres)) i)::float8
+				/ greatest((SELECT count(*) FROM (SELECT unnest(a.genres) UNION SELECT unnest(b.genres)) u), 1)
+			+ $2 * greatest(0, 1 - abs(a.year - b.year) / $5::float8)
+			+ $3 * least(COALESCE(shared_credits.people, 0), $6)::float8 / $6
+			+ $4 * COALESCE(cooccurrence.users / sqrt(audience_a.users * audience_b.users), 0)
+			AS score
+		FROM candidates
+		INNER JOIN live a ON a.id = candidates.movie_id
+		INNER JOIN live b ON b.id = candidates.similar_id
+		LEFT JOIN shared_credits ON shared_credits.movie_id = candidates.movie_id AND shared_credits.similar_id = candidates.similar_id
+		LEFT JOIN cooccurrence ON cooccurrence.movie_id = candidates.movie_id AND cooccurrence.similar_id = candidates.similar_id
+		LEFT JOIN audience audience_a ON audience_a.movie_id = candidates.movie_id
+		LEFT JOIN audience audience_b ON audience_b.movie_id = candidates.similar_id
+	),
+	ranked AS (
+		SELECT movie_id, similar_id, score,
+			row_number() OVER (PARTITION BY movie_id ORDER BY score DESC, similar_id ASC) AS rank
+		FROM scored WHERE score > 0
+	)
+	INSERT INTO movie_similarities (movie_id, similar_id, score, rank)
+	SELECT movie_id, similar_id, score, rank FROM ranked WHERE rank <= $7`
+
+	args := []any{
+		weights.Genres,
+		weights.Year,
+		weights.Credits,
+		weights.CoOccurrence,
+		similarityYearSpan,
+		similarityCreditCap,
+		limit,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_similarities`)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return rows, tx.Commit()
+}
+
+// GetForMovie returns the precomputed movies most similar to the movie, best
+// first, skipping movies trashed since the last recompute.
+func (m SimilarityModel) GetForMovie(movieID int64, limit int) ([]*SimilarMovie, error) {
+	query := `SELECT movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres,
+	movies.rating, movies.votes, movies.version, movies.poster_key, movies.poster_url, movies.external_ids,
+	movie_similarities.score
+	FROM movie_similarities INNER JOIN movies ON movies.id = movie_similarities.similar_id
+	WHERE movie_similarities.movie_id = $1 AND movies.deleted_at IS NULL
+	ORDER BY movie_similarities.rank ASC LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	similar := []*SimilarMovie{}
+
+	for rows.Next() {
+		movie := SimilarMovie{Movie: &Movie{}}
+
+		errScan := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Rating,
+			&movie.Votes,
+			&movie.Version,
+			&movie.PosterKey,
+			&movie.PosterURL,
+			&movie.ExternalIDs,
+			&movie.Score,
+		)
+		if errScan != nil {
+			return nil, errScan
+		}
+
+		similar = append(similar, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return similar, nil
+}
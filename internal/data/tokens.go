@@ -8,6 +8,7 @@ import (
 	"encoding/base32"
 	"time"
 
+	"greenlight.swsd2544.net/internal/errcode"
 	"greenlight.swsd2544.net/internal/validator"
 )
 
@@ -46,9 +47,14 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	return token, nil
 }
 
+var (
+	codeTokenRequired    = errcode.New("token.required", "must be provided")
+	codeTokenWrongLength = errcode.New("token.wrong_length", "must be 26 bytes long")
+)
+
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
-	v.Check(tokenPlaintext != "", "token", "must be provided")
-	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+	v.Check(tokenPlaintext != "", "token", codeTokenRequired)
+	v.Check(len(tokenPlaintext) == 26, "token", codeTokenWrongLength)
 }
 
 type TokenModel struct {
@@ -0,0 +1,214 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.swsd2544.net/internal/errcode"
+	"greenlight.swsd2544.net/internal/validator"
+)
+
+// Locales maps every locale movies can be translated into to the Postgres
+// text-search configuration its titles are indexed with. Postgres ships no
+// Thai configuration, so Thai titles use "simple" and are also matched by
+// substring (see MovieCriteria.where).
+var Locales = map[string]string{
+	"en": "english",
+	"th": "simple",
+}
+
+// SupportedLocales returns the keys of Locales in a stable order.
+func SupportedLocales() []string {
+	locales := make([]string, 0, len(Locales))
+	for locale := range Locales {
+		locales = append(locales, locale)
+	}
+
+	sort.Strings(locales)
+
+	return locales
+}
+
+type MovieTranslation struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	Locale    string    `json:"locale"`
+	Title     string    `json:"title"`
+	Synopsis  string    `json:"synopsis,omitempty"`
+	MovieID   int64     `json:"-"`
+}
+
+var (
+	codeTranslationTitleRequired   = errcode.New("translation.title.required", "must be provided")
+	codeTranslationTitleTooLong    = errcode.New("translation.title.too_long", "must not be more than 500 bytes long")
+	codeTranslationSynopsisTooLong = errcode.New("translation.synopsis.too_long", "must not be more than 10000 bytes long")
+	codeLocaleUnsupported          = errcode.New("locale.unsupported", "must be one of {locales}")
+)
+
+func ValidateLocale(v *validator.Validator, key, locale string) {
+	_, ok := Locales[locale]
+	v.Check(ok, key, codeLocaleUnsupported, errcode.Params{"locales": SupportedLocales()})
+}
+
+func ValidateMovieTranslation(v *validator.Validator, translation *MovieTranslation) {
+	ValidateLocale(v, "locale", translation.Locale)
+
+	v.Check(translation.Title != "", "title", codeTranslationTitleRequired)
+	v.Check(len(translation.Title) <= 500, "title", codeTranslationTitleTooLong)
+
+	v.Check(len(translation.Synopsis) <= 10_000, "synopsis", codeTranslationSynopsisTooLong)
+}
+
+type MovieTranslationModel struct {
+	DB *sql.DB
+}
+
+// Upsert creates the movie's translation for the locale or replaces the
+// existing one.
+func (m MovieTranslationModel) Upsert(translation *MovieTranslation) error {
+	query := `INSERT INTO movie_translations (movie_id, locale, title, synopsis, search_config)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (movie_id, locale) DO UPDATE
+	SET title = EXCLUDED.title, synopsis = EXCLUDED.synopsis, search_config = EXCLUDED.search_config, updated_at = NOW()
+	RETURNING updated_at`
+
+	args := []any{
+		translation.MovieID,
+		translation.Locale,
+		translation.Title,
+		translation.Synopsis,
+		Locales[translation.Locale],
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&translation.UpdatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "movie_translations" violates foreign key constraint "movie_translations_movie_id_fkey"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m MovieTranslationModel) Delete(movieID int64, locale string) error {
+	query := `DELETE FROM movie_translations WHERE movie_id = $1 AND locale = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, locale)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m MovieTranslationModel) GetAllForMovie(movieID int64) ([]*MovieTranslation, error) {
+	query := `SELECT movie_id, locale, updated_at, title, synopsis
+	FROM movie_translations WHERE movie_id = $1 ORDER BY locale`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	translations := []*MovieTranslation{}
+
+	for rows.Next() {
+		var translation MovieTranslation
+
+		errScan := rows.Scan(
+			&translation.MovieID,
+			&translation.Locale,
+			&translation.UpdatedAt,
+			&translation.Title,
+			&translation.Synopsis,
+		)
+		if errScan != nil {
+			return nil, errScan
+		}
+
+		translations = append(translations, &translation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return translations, nil
+}
+
+// Localize replaces the titles of the given movies with their translations
+// into locale, keeping the original in OriginalTitle. Movies without a
+// translation keep their original title.
+func (m MovieTranslationModel) Localize(locale string, movies ...*Movie) error {
+	if locale == "" || len(movies) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*Movie, len(movies))
+	ids := make([]int64, 0, len(movies))
+
+	for _, movie := range movies {
+		byID[movie.ID] = movie
+		ids = append(ids, movie.ID)
+	}
+
+	query := `SELECT movie_id, title, synopsis FROM movie_translations
+	WHERE locale = $1 AND movie_id = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, locale, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var movieID int64
+		var title, synopsis string
+
+		errScan := rows.Scan(&movieID, &title, &synopsis)
+		if errScan != nil {
+			return errScan
+		}
+
+		if movie, ok := byID[movieID]; ok {
+			movie.OriginalTitle = movie.Title
+			movie.Title = title
+			movie.Synopsis = synopsis
+		}
+	}
+
+	return rows.Err()
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
+	"greenlight.swsd2544.net/internal/errcode"
 	"greenlight.swsd2544.net/internal/validator"
 )
 
@@ -19,9 +20,10 @@ var AnonymousUser = &User{}
 
 type User struct {
 	CreatedAt time.Time `json:"created_at"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
+	Name      string    `json:"name" validate:"required,max=500"`
+	Email     string    `json:"email" validate:"required,regex=email"`
 	Password  password  `json:"-"`
+	Links     Links     `json:"_links,omitempty"`
 	ID        int64     `json:"id"`
 	Version   int       `json:"-"`
 	Activated bool      `json:"activated"`
@@ -62,22 +64,32 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
+var (
+	codeUserEmailRequired    = errcode.New("user.email.required", "must be provided")
+	codeUserEmailInvalid     = errcode.New("user.email.invalid", "must be a valid email address")
+	codeUserPasswordRequired = errcode.New("user.password.required", "must be provided")
+	codeUserPasswordTooShort = errcode.New("user.password.too_short", "must be at least 8 bytes long")
+	codeUserPasswordTooLong  = errcode.New("user.password.too_long", "must not be more than 72 bytes long")
+)
+
+var userRules = validator.NewRules("user", User{})
+
 func ValidateEmail(v *validator.Validator, email string) {
-	v.Check(email != "", "email", "must be provided")
-	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+	v.Check(email != "", "email", codeUserEmailRequired)
+
+	if email != "" {
+		v.Check(validator.Matches(email, validator.EmailRX), "email", codeUserEmailInvalid)
+	}
 }
 
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
-	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Check(password != "", "password", codeUserPasswordRequired)
+	v.Check(len(password) >= 8, "password", codeUserPasswordTooShort)
+	v.Check(len(password) <= 72, "password", codeUserPasswordTooLong)
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
-	v.Check(user.Name != "", "name", "must be provided")
-	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
-
-	ValidateEmail(v, user.Email)
+	userRules.Validate(v, user)
 
 	if user.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.plaintext)
@@ -0,0 +1,136 @@
+// Package errcode keeps the catalog of stable error codes. Clients match on a
+// code rather than on its message, so messages can be reworded without
+// breaking them.
+package errcode
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Code identifies one kind of error, such as "auth.token_invalid" or
+// "movie.year.too_early".
+type Code string
+
+// Params are the values substituted into a message's {name} placeholders.
+// They are also sent to clients, so they can build their own messages.
+type Params map[string]any
+
+// Entry is a code in the catalog with the English message it is reported
+// with. Messages may contain {name} placeholders for params.
+type Entry struct {
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+}
+
+var (
+	mu       sync.RWMutex
+	messages = make(map[Code]string)
+)
+
+// New registers code with its English message and returns it. It is meant to
+// be called from package-level variable declarations. Registering a code again
+// with the same message returns it unchanged; registering it with a different
+// message panics.
+func New(code, message string) Code {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if existing, exists := messages[Code(code)]; exists && existing != message {
+		panic(fmt.Sprintf("errcode: code %q registered with messages %q and %q", code, existing, message))
+	}
+
+	messages[Code(code)] = message
+
+	return Code(code)
+}
+
+// Message returns the English message of the code with its placeholders
+// filled in from params.
+func (c Code) Message(params ...Params) string {
+	mu.RLock()
+	message, ok := messages[c]
+	mu.RUnlock()
+
+	if !ok {
+		return string(c)
+	}
+
+	return Format(message, params...)
+}
+
+// Format fills the {name} placeholders of message from params. Slices of
+// strings are joined with commas and placeholders without a param are left
+// as they are.
+func Format(message string, params ...Params) string {
+	if len(params) == 0 || !strings.Contains(message, "{") {
+		return message
+	}
+
+	var b strings.Builder
+
+	for {
+		start := strings.IndexByte(message, '{')
+		if start < 0 {
+			break
+		}
+
+		end := strings.IndexByte(message[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+
+		b.WriteString(message[:start])
+
+		if value, ok := lookup(message[start+1:end], params); ok {
+			b.WriteString(formatValue(value))
+		} else {
+			b.WriteString(message[start : end+1])
+		}
+
+		message = message[end+1:]
+	}
+
+	b.WriteString(message)
+
+	return b.String()
+}
+
+func lookup(name string, params []Params) (any, bool) {
+	for i := len(params) - 1; i >= 0; i-- {
+		if value, ok := params[i][name]; ok {
+			return value, true
+		}
+	}
+
+	return nil, false
+}
+
+func formatValue(value any) string {
+	switch value := value.(type) {
+	case []string:
+		return strings.Join(value, ", ")
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// All returns every registered code, sorted by code.
+func All() []Entry {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	entries := make([]Entry, 0, len(messages))
+	for code, message := range messages {
+		entries = append(entries, Entry{Code: code, Message: message})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Code < entries[j].Code
+	})
+
+	return entries
+}
@@ -0,0 +1,170 @@
+// Package i18n translates error messages by their code. Catalogs for each
+// supported language are embedded from locales/<language>.json, where each
+// code maps either to a message or, for messages that depend on a count, to
+// plural forms:
+//
+//	"movie.genres.too_few": {"count": "min", "one": "...", "other": "..."}
+//
+// count names the param that selects the form.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"greenlight.swsd2544.net/internal/errcode"
+)
+
+// Fallback is the language used for codes a catalog doesn't translate.
+const Fallback = "en"
+
+//go:embed locales/*.json
+var files embed.FS
+
+// pluralRules pick the CLDR plural category of a count for each language.
+var pluralRules = map[string]func(n float64) string{
+	"en": func(n float64) string {
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	},
+	"th": func(float64) string {
+		return "other"
+	},
+}
+
+type message struct {
+	Forms map[string]string
+	Count string
+}
+
+func (m *message) UnmarshalJSON(b []byte) error {
+	var text string
+	if err := json.Unmarshal(b, &text); err == nil {
+		m.Forms = map[string]string{"other": text}
+		return nil
+	}
+
+	var forms map[string]string
+	if err := json.Unmarshal(b, &forms); err != nil {
+		return err
+	}
+
+	m.Count = forms["count"]
+	delete(forms, "count")
+
+	if m.Count == "" || forms["other"] == "" {
+		return fmt.Errorf("plural forms need a count and an other form")
+	}
+
+	m.Forms = forms
+
+	return nil
+}
+
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]map[errcode.Code]message {
+	names, err := files.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+
+	catalogs := make(map[string]map[errcode.Code]message, len(names))
+
+	for _, name := range names {
+		language := strings.TrimSuffix(name.Name(), ".json")
+
+		if _, ok := pluralRules[language]; !ok {
+			panic(fmt.Sprintf("i18n: no plural rule for language %q", language))
+		}
+
+		b, err := files.ReadFile(path.Join("locales", name.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		var catalog map[errcode.Code]message
+		if err := json.Unmarshal(b, &catalog); err != nil {
+			panic(fmt.Sprintf("i18n: %s: %s", name.Name(), err))
+		}
+
+		catalogs[language] = catalog
+	}
+
+	return catalogs
+}
+
+// Languages returns the languages with a catalog, sorted.
+func Languages() []string {
+	languages := make([]string, 0, len(catalogs))
+	for language := range catalogs {
+		languages = append(languages, language)
+	}
+
+	sort.Strings(languages)
+
+	return languages
+}
+
+// Message returns the message of code in language with its placeholders filled
+// in from params. Codes missing from the language's catalog use the fallback
+// catalog, and codes missing from that use the code's registered message.
+func Message(language string, code errcode.Code, params errcode.Params) string {
+	for _, l := range []string{language, Fallback} {
+		m, ok := catalogs[l][code]
+		if !ok {
+			continue
+		}
+
+		form := "other"
+		if n, ok := number(params[m.Count]); ok && m.Count != "" {
+			form = pluralRules[l](n)
+		}
+
+		text, ok := m.Forms[form]
+		if !ok {
+			text = m.Forms["other"]
+		}
+
+		return errcode.Format(text, params)
+	}
+
+	return code.Message(params)
+}
+
+// Missing returns the registered codes each catalog doesn't translate, by
+// language. Languages with every code translated are left out.
+func Missing() map[string][]errcode.Code {
+	missing := make(map[string][]errcode.Code)
+
+	for _, entry := range errcode.All() {
+		for language, catalog := range catalogs {
+			if _, ok := catalog[entry.Code]; !ok {
+				missing[language] = append(missing[language], entry.Code)
+			}
+		}
+	}
+
+	return missing
+}
+
+func number(value any) (float64, bool) {
+	switch value := value.(type) {
+	case int:
+		return float64(value), true
+	case int32:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case float64:
+		return value, true
+	default:
+		return 0, false
+	}
+}
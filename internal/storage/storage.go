@@ -0,0 +1,59 @@
+// Package storage stores uploaded files such as movie posters behind a
+// backend-agnostic interface.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("storage: object not found")
+	ErrInvalidKey = errors.New("storage: invalid key")
+)
+
+// Object is a stored file opened for reading.
+type Object struct {
+	io.ReadSeekCloser
+	ModTime     time.Time
+	ContentType string
+	Size        int64
+}
+
+// Storage is implemented by every file storage backend. Keys are
+// slash-separated relative paths such as "posters/12/ab12cd/original.jpg".
+type Storage interface {
+	// Put stores the contents of r under key, replacing any existing object.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Open returns the object stored under key, or ErrNotFound.
+	Open(ctx context.Context, key string) (*Object, error)
+	// Delete removes the object stored under key. Deleting a key that does
+	// not exist is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns the address clients use to fetch the object.
+	URL(key string) string
+}
+
+// ValidKey reports whether key is a clean relative path that can't escape the
+// storage root.
+func ValidKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+
+	if path.Clean(key) != key {
+		return false
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." || segment == "." {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,278 @@
+package validator
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"greenlight.swsd2544.net/internal/errcode"
+)
+
+// Pattern is a named regular expression for the regex rule, with the message
+// reported when a value doesn't match it.
+type Pattern struct {
+	RX      *regexp.Regexp
+	Message string
+}
+
+// Patterns are the named patterns a regex rule can refer to. A regex rule
+// naming anything else is compiled as a regular expression itself.
+var Patterns = map[string]Pattern{
+	"email": {RX: EmailRX, Message: "must be a valid email address"},
+}
+
+// reasons are the messages of the reasons rules report failures with.
+var reasons = map[string]string{
+	"required":     "must be provided",
+	"too_short":    "must be at least {min} bytes long",
+	"too_long":     "must not be more than {max} bytes long",
+	"too_few":      "must contain at least {min} values",
+	"too_many":     "must not contain more than {max} values",
+	"too_small":    "must be at least {min}",
+	"too_large":    "must not be more than {max}",
+	"too_early":    "must not be earlier than {min}",
+	"not_positive": "must be a positive integer",
+	"invalid":      "must be one of {values}",
+	"duplicate":    "must not contain duplicate values",
+}
+
+// Rules validate the fields of a struct type from their validate tags, so
+// simple constraints can be declared on the fields instead of written out as
+// checks. A tag is a comma-separated list of rules:
+//
+//	required     the field must not be its zero value
+//	min=N        strings must be at least N bytes long, slices and maps must
+//	             have at least N elements and numbers must be at least N
+//	max=N        the upper bound counterpart of min
+//	oneof=a b c  the string must be one of the space-separated values
+//	regex=name   the string must match the named pattern in Patterns, or
+//	             name compiled as a regular expression
+//	unique       the slice must not contain duplicate elements
+//
+// Fields holding their zero value are only checked by required. Failures are
+// reported under the field's JSON name with the code
+// "<prefix>.<field>.<reason>", where the reason depends on the rule and the
+// field's kind. A rule can give another reason after an @, as in
+// min=1888@too_early.
+type Rules struct {
+	fields []fieldRules
+}
+
+type fieldRules struct {
+	key   string
+	index []int
+	rules []rule
+}
+
+type rule struct {
+	params   errcode.Params
+	check    func(reflect.Value) bool
+	code     errcode.Code
+	required bool
+}
+
+// NewRules compiles the validate tags of sample's struct type. Codes are
+// registered as the rules are compiled, so rules should be kept in
+// package-level variables for the error catalog to list them. It panics on
+// malformed tags.
+func NewRules(prefix string, sample any) *Rules {
+	t := reflect.TypeOf(sample)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validator: rules need a struct, got %s", t))
+	}
+
+	rs := &Rules{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag, ok := field.Tag.Lookup("validate")
+		if !ok {
+			continue
+		}
+
+		fr := fieldRules{key: jsonName(field), index: field.Index}
+
+		for _, spec := range strings.Split(tag, ",") {
+			r, err := compileRule(prefix+"."+fr.key, field.Type, spec)
+			if err != nil {
+				panic(fmt.Sprintf("validator: %s.%s: %s", t.Name(), field.Name, err))
+			}
+			fr.rules = append(fr.rules, r)
+		}
+
+		rs.fields = append(rs.fields, fr)
+	}
+
+	return rs
+}
+
+// Validate checks value, a struct or a pointer to one of the type the rules
+// were compiled for, and adds its failures to v.
+func (rs *Rules) Validate(v *Validator, value any) {
+	rv := reflect.Indirect(reflect.ValueOf(value))
+
+	for _, fr := range rs.fields {
+		field := rv.FieldByIndex(fr.index)
+
+		for _, r := range fr.rules {
+			if field.IsZero() {
+				if r.required {
+					v.AddError(fr.key, r.code, r.params)
+				}
+				continue
+			}
+
+			if !r.required && !r.check(field) {
+				v.AddError(fr.key, r.code, r.params)
+			}
+		}
+	}
+}
+
+func compileRule(codePrefix string, t reflect.Type, spec string) (rule, error) {
+	spec, reason, _ := strings.Cut(strings.TrimSpace(spec), "@")
+	name, param, _ := strings.Cut(spec, "=")
+
+	var (
+		r             rule
+		defaultReason string
+		message       string
+	)
+
+	switch name {
+	case "required":
+		r.required = true
+		defaultReason = "required"
+	case "min", "max":
+		bound, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return rule{}, fmt.Errorf("invalid %s bound %q", name, param)
+		}
+
+		size, kindReasons, err := sizeOf(t)
+		if err != nil {
+			return rule{}, fmt.Errorf("%s: %w", name, err)
+		}
+
+		if name == "min" {
+			r.check = func(v reflect.Value) bool { return size(v) >= bound }
+			defaultReason = kindReasons[0]
+		} else {
+			r.check = func(v reflect.Value) bool { return size(v) <= bound }
+			defaultReason = kindReasons[1]
+		}
+
+		r.params = errcode.Params{name: paramNumber(bound)}
+	case "oneof":
+		if t.Kind() != reflect.String {
+			return rule{}, fmt.Errorf("oneof needs a string, got %s", t)
+		}
+
+		values := strings.Fields(param)
+		r.check = func(v reflect.Value) bool { return PermittedValue(v.String(), values...) }
+		r.params = errcode.Params{"values": values}
+		defaultReason = "invalid"
+	case "regex":
+		if t.Kind() != reflect.String {
+			return rule{}, fmt.Errorf("regex needs a string, got %s", t)
+		}
+
+		pattern, ok := Patterns[param]
+		if !ok {
+			rx, err := regexp.Compile(param)
+			if err != nil {
+				return rule{}, fmt.Errorf("invalid regex: %w", err)
+			}
+			pattern = Pattern{RX: rx, Message: "must match the pattern {pattern}"}
+		}
+
+		r.check = func(v reflect.Value) bool { return pattern.RX.MatchString(v.String()) }
+		r.params = errcode.Params{"pattern": param}
+		defaultReason = "invalid"
+		message = pattern.Message
+	case "unique":
+		if t.Kind() != reflect.Slice || !t.Elem().Comparable() {
+			return rule{}, fmt.Errorf("unique needs a slice of comparable values, got %s", t)
+		}
+
+		r.check = uniqueElements
+		defaultReason = "duplicate"
+	default:
+		return rule{}, fmt.Errorf("unknown rule %q", name)
+	}
+
+	switch {
+	case reason != "":
+		var ok bool
+		if message, ok = reasons[reason]; !ok {
+			return rule{}, fmt.Errorf("unknown reason %q", reason)
+		}
+	case message == "":
+		reason, message = defaultReason, reasons[defaultReason]
+	default:
+		reason = defaultReason
+	}
+
+	r.code = errcode.New(codePrefix+"."+reason, message)
+
+	return r, nil
+}
+
+// sizeOf returns what min and max compare for values of type t, along with
+// the reasons they fail with.
+func sizeOf(t reflect.Type) (func(reflect.Value) float64, [2]string, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return func(v reflect.Value) float64 { return float64(v.Len()) }, [2]string{"too_short", "too_long"}, nil
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return func(v reflect.Value) float64 { return float64(v.Len()) }, [2]string{"too_few", "too_many"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(v reflect.Value) float64 { return float64(v.Int()) }, [2]string{"too_small", "too_large"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return func(v reflect.Value) float64 { return float64(v.Uint()) }, [2]string{"too_small", "too_large"}, nil
+	case reflect.Float32, reflect.Float64:
+		return func(v reflect.Value) float64 { return v.Float() }, [2]string{"too_small", "too_large"}, nil
+	default:
+		return nil, [2]string{}, fmt.Errorf("can't compare the size of %s", t)
+	}
+}
+
+// paramNumber returns bound as an integer when it is one, so params read
+// max=500 rather than max=500.0.
+func paramNumber(bound float64) any {
+	if bound == float64(int64(bound)) {
+		return int64(bound)
+	}
+
+	return bound
+}
+
+func uniqueElements(v reflect.Value) bool {
+	seen := make(map[any]bool, v.Len())
+
+	for i := 0; i < v.Len(); i++ {
+		element := v.Index(i).Interface()
+		if seen[element] {
+			return false
+		}
+		seen[element] = true
+	}
+
+	return true
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+
+	return name
+}
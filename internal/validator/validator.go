@@ -1,33 +1,103 @@
 package validator
 
-import "regexp"
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"greenlight.swsd2544.net/internal/errcode"
+)
 
 var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+// FieldError is one failed check of a field.
+type FieldError struct {
+	Params  errcode.Params `json:"params,omitempty"`
+	Code    errcode.Code   `json:"code"`
+	Message string         `json:"message"`
+}
+
+// Validator collects failed checks by field key. Fields holds every failure
+// of a key in the order they were added; Errors holds just the first message
+// of each key, which is the shape clients have always received.
 type Validator struct {
 	Errors map[string]string
+	Fields map[string][]FieldError
 }
 
 func New() *Validator {
-	return &Validator{Errors: make(map[string]string)}
+	return &Validator{
+		Errors: make(map[string]string),
+		Fields: make(map[string][]FieldError),
+	}
 }
 
 func (v *Validator) Valid() bool {
-	return len(v.Errors) == 0
+	return len(v.Fields) == 0
 }
 
-func (v *Validator) AddError(key, message string) {
+// AddError records a failure of key. The message is the code's message with
+// params filled in, and the params are kept so clients can build their own.
+func (v *Validator) AddError(key string, code errcode.Code, params ...errcode.Params) {
+	merged := mergeParams(params)
+	message := code.Message(merged)
+
 	if _, exists := v.Errors[key]; !exists {
 		v.Errors[key] = message
 	}
+
+	v.Fields[key] = append(v.Fields[key], FieldError{Code: code, Message: message, Params: merged})
 }
 
-func (v *Validator) Check(ok bool, key, message string) {
+func (v *Validator) Check(ok bool, key string, code errcode.Code, params ...errcode.Params) {
 	if !ok {
-		v.AddError(key, message)
+		v.AddError(key, code, params...)
 	}
 }
 
+func mergeParams(params []errcode.Params) errcode.Params {
+	switch len(params) {
+	case 0:
+		return nil
+	case 1:
+		return params[0]
+	}
+
+	merged := make(errcode.Params)
+	for _, p := range params {
+		for name, value := range p {
+			merged[name] = value
+		}
+	}
+
+	return merged
+}
+
+// Path builds the key of a nested or indexed field. Strings are joined with
+// dots and ints become indexes, so Path("credits", 2, "role") is
+// "credits[2].role".
+func Path(elems ...any) string {
+	var b strings.Builder
+
+	for _, elem := range elems {
+		switch elem := elem.(type) {
+		case int:
+			b.WriteByte('[')
+			b.WriteString(strconv.Itoa(elem))
+			b.WriteByte(']')
+		case string:
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(elem)
+		default:
+			panic("validator: path elements must be strings or ints")
+		}
+	}
+
+	return b.String()
+}
+
 func PermittedValue[T comparable](value T, permittedValues ...T) bool {
 	for i := range permittedValues {
 		if value == permittedValues[i] {
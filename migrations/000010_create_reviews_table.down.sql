@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code = 'reviews:moderate';
+ALTER TABLE movies DROP COLUMN IF EXISTS votes;
+ALTER TABLE movies DROP COLUMN IF EXISTS rating;
+DROP TABLE IF EXISTS reviews;
\ No newline at end of file
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS reviews (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  rating integer NOT NULL,
+  body text NOT NULL DEFAULT '',
+  status text NOT NULL DEFAULT 'published',
+  version integer NOT NULL DEFAULT 1,
+  CONSTRAINT reviews_rating_check CHECK (rating BETWEEN 1 AND 10),
+  CONSTRAINT reviews_status_check CHECK (status IN ('pending', 'published', 'rejected')),
+  CONSTRAINT reviews_movie_id_user_id_key UNIQUE (movie_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating numeric(4, 2) NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS votes integer NOT NULL DEFAULT 0;
+
+INSERT INTO permissions (code)
+VALUES
+  ('reviews:moderate');
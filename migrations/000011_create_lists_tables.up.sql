@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS lists (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  name text NOT NULL,
+  is_default bool NOT NULL DEFAULT false,
+  public bool NOT NULL DEFAULT false,
+  share_token_hash bytea UNIQUE,
+  version integer NOT NULL DEFAULT 1
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS lists_user_id_default_idx ON lists (user_id) WHERE is_default;
+
+CREATE TABLE IF NOT EXISTS list_entries (
+  list_id bigint NOT NULL REFERENCES lists ON DELETE CASCADE,
+  movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+  position integer NOT NULL,
+  added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  watched_on date,
+  PRIMARY KEY (list_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS list_entries_movie_id_idx ON list_entries (movie_id);
@@ -0,0 +1,55 @@
+CREATE TABLE IF NOT EXISTS genres (
+  slug text PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  name text NOT NULL,
+  aliases text[] NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS genres_aliases_idx ON genres USING GIN (aliases);
+
+INSERT INTO genres (slug, name, aliases)
+VALUES
+  ('action', 'Action', '{}'),
+  ('adventure', 'Adventure', '{}'),
+  ('animation', 'Animation', '{animated}'),
+  ('comedy', 'Comedy', '{}'),
+  ('crime', 'Crime', '{}'),
+  ('documentary', 'Documentary', '{doc}'),
+  ('drama', 'Drama', '{}'),
+  ('family', 'Family', '{}'),
+  ('fantasy', 'Fantasy', '{}'),
+  ('history', 'History', '{historical}'),
+  ('horror', 'Horror', '{}'),
+  ('music', 'Music', '{musical}'),
+  ('mystery', 'Mystery', '{}'),
+  ('romance', 'Romance', '{romantic}'),
+  ('science-fiction', 'Science Fiction', '{sci-fi,scifi,sf}'),
+  ('thriller', 'Thriller', '{}'),
+  ('war', 'War', '{}'),
+  ('western', 'Western', '{}')
+ON CONFLICT DO NOTHING;
+
+-- Must match data.GenreSlug.
+CREATE FUNCTION pg_temp.genre_slug(value text) RETURNS text AS $$
+  SELECT trim(both '-' from regexp_replace(lower(value), '[^a-z0-9]+', '-', 'g'))
+$$ LANGUAGE sql IMMUTABLE;
+
+INSERT INTO genres (slug, name)
+SELECT DISTINCT ON (pg_temp.genre_slug(genre)) pg_temp.genre_slug(genre), genre
+FROM movies, unnest(movies.genres) AS genre
+WHERE pg_temp.genre_slug(genre) <> ''
+AND NOT EXISTS (
+  SELECT 1 FROM genres
+  WHERE genres.slug = pg_temp.genre_slug(genre) OR pg_temp.genre_slug(genre) = ANY(genres.aliases)
+)
+ORDER BY pg_temp.genre_slug(genre), genre;
+
+UPDATE movies SET genres = ARRAY(
+  SELECT genres.slug FROM unnest(movies.genres) WITH ORDINALITY AS t(genre, ord)
+  INNER JOIN genres ON genres.slug = pg_temp.genre_slug(t.genre) OR pg_temp.genre_slug(t.genre) = ANY(genres.aliases)
+  GROUP BY genres.slug ORDER BY min(t.ord)
+);
+
+INSERT INTO permissions (code)
+VALUES
+  ('genres:write');
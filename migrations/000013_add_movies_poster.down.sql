@@ -0,0 +1,3 @@
+ALTER TABLE movies
+  DROP COLUMN IF EXISTS poster_url,
+  DROP COLUMN IF EXISTS poster_key;
\ No newline at end of file
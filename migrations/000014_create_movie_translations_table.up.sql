@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS movie_translations (
+  movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+  locale text NOT NULL,
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  title text NOT NULL,
+  synopsis text NOT NULL DEFAULT '',
+  search_config regconfig NOT NULL DEFAULT 'simple',
+  title_tsv tsvector GENERATED ALWAYS AS (to_tsvector(search_config, title)) STORED,
+  PRIMARY KEY (movie_id, locale)
+);
+
+CREATE INDEX IF NOT EXISTS movie_translations_title_tsv_idx ON movie_translations USING GIN (title_tsv);
@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'movies:merge';
+DROP TABLE IF EXISTS audit_records;
+DROP TABLE IF EXISTS movie_redirects;
+DROP INDEX IF EXISTS movies_title_key_year_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS title_key;
\ No newline at end of file
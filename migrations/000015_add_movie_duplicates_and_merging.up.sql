@@ -0,0 +1,25 @@
+-- Computed by data.NormalizeTitle, which SQL can't reproduce for every
+-- script. Existing movies are filled in by the API when it starts.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS title_key text;
+
+CREATE INDEX IF NOT EXISTS movies_title_key_year_idx ON movies (title_key, year) WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS movie_redirects (
+  old_id bigint PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS movie_redirects_movie_id_idx ON movie_redirects (movie_id);
+
+CREATE TABLE IF NOT EXISTS audit_records (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  user_id bigint REFERENCES users ON DELETE SET NULL,
+  action text NOT NULL,
+  details jsonb NOT NULL DEFAULT '{}'
+);
+
+INSERT INTO permissions (code)
+VALUES
+  ('movies:merge');
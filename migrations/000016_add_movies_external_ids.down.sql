@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS movies_external_ids_wikidata_key;
+DROP INDEX IF EXISTS movies_external_ids_tmdb_key;
+DROP INDEX IF EXISTS movies_external_ids_imdb_key;
+ALTER TABLE movie_revisions DROP COLUMN IF EXISTS external_ids;
+ALTER TABLE movies DROP COLUMN IF EXISTS external_ids;
\ No newline at end of file
@@ -0,0 +1,11 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS external_ids jsonb NOT NULL DEFAULT '{}';
+-- Revisions recorded before now don't know the movie's external IDs, which
+-- is told apart from having none by leaving them NULL.
+ALTER TABLE movie_revisions ADD COLUMN IF NOT EXISTS external_ids jsonb;
+
+-- Lookups use external_ids ->> 'provider' outside the trash, so these indexes
+-- serve them too. A movie in the trash keeps its identifiers, but gives them up
+-- to live movies until it is restored.
+CREATE UNIQUE INDEX IF NOT EXISTS movies_external_ids_imdb_key ON movies ((external_ids ->> 'imdb')) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS movies_external_ids_tmdb_key ON movies ((external_ids ->> 'tmdb')) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS movies_external_ids_wikidata_key ON movies ((external_ids ->> 'wikidata')) WHERE deleted_at IS NULL;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS movie_similarities (
+  movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+  similar_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+  score double precision NOT NULL,
+  rank integer NOT NULL,
+  PRIMARY KEY (movie_id, similar_id)
+);
+
+CREATE INDEX IF NOT EXISTS movie_similarities_movie_id_rank_idx ON movie_similarities (movie_id, rank);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  user_id bigint NOT NULL,
+  client text NOT NULL DEFAULT '',
+  key text NOT NULL,
+  fingerprint bytea NOT NULL,
+  status integer,
+  header jsonb NOT NULL DEFAULT '{}',
+  body bytea,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  expiry timestamp(0) with time zone NOT NULL,
+  PRIMARY KEY (user_id, client, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expiry_idx ON idempotency_keys (expiry);